@@ -0,0 +1,386 @@
+// cmd/archive/main.go
+// Records the traffic of a binding pattern to a JSONL file and replays it.
+//
+//	archive -key 'order.eu.*' -out eu.jsonl record       record until Ctrl-C
+//	archive -key 'order.#' -duration 10m -out orders.jsonl record
+//	archive -in eu.jsonl replay                          replay at the original pace
+//	archive -in eu.jsonl -speed 10 replay                ten times faster
+//	archive -in eu.jsonl -speed 0 -to-exchange peril_staging replay
+//
+// Each line holds one message: when it was received, its exchange and
+// routing key, headers, properties and body. JSON bodies are kept as JSON
+// so the file can be read and grepped, anything else is base64.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/config"
+	"github.com/abdooman21/ecom-plat/internal/pubsub"
+	"github.com/abdooman21/ecom-plat/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// record is one line of the archive
+type record struct {
+	Time            time.Time       `json:"time"`
+	Exchange        string          `json:"exchange"`
+	RoutingKey      string          `json:"routing_key"`
+	Headers         amqp.Table      `json:"headers,omitempty"`
+	ContentType     string          `json:"content_type,omitempty"`
+	ContentEncoding string          `json:"content_encoding,omitempty"`
+	DeliveryMode    uint8           `json:"delivery_mode,omitempty"`
+	Priority        uint8           `json:"priority,omitempty"`
+	CorrelationID   string          `json:"correlation_id,omitempty"`
+	ReplyTo         string          `json:"reply_to,omitempty"`
+	MessageID       string          `json:"message_id,omitempty"`
+	Timestamp       time.Time       `json:"timestamp,omitzero"`
+	Type            string          `json:"type,omitempty"`
+	AppID           string          `json:"app_id,omitempty"`
+	JSON            json.RawMessage `json:"json,omitempty"` // body, when it is valid JSON
+	Body            []byte          `json:"body,omitempty"` // body otherwise, base64
+}
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	logger := cfg.App.Logger()
+	slog.SetDefault(logger)
+	pubsub.SetLogger(logger)
+
+	url := flag.String("url", cfg.RabbitMQ.URL, "RabbitMQ url")
+	exchange := flag.String("exchange", routing.ExchangePerilTopic, "record: exchange to bind to")
+	key := flag.String("key", "#", "record: binding pattern to record (e.g. order.eu.*); replay: only replay matching keys")
+	out := flag.String("out", "-", "record: file to write, - for stdout")
+	duration := flag.Duration("duration", 0, "record: stop after this long (0 records until interrupted)")
+	count := flag.Int("count", 0, "record: stop after this many messages; replay: replay at most this many (0 for all)")
+	in := flag.String("in", "-", "replay: file to read, - for stdin")
+	speed := flag.Float64("speed", 1, "replay: pace relative to the recording, e.g. 10 is ten times faster (0 sends without waiting)")
+	toExchange := flag.String("to-exchange", "", "replay: exchange to publish to instead of the recorded one")
+	dryRun := flag.Bool("dry-run", false, "replay: show what would be sent without publishing")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: archive [flags] record|replay\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *speed < 0 {
+		log.Fatalf("-speed must not be negative")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch flag.Arg(0) {
+	case "record":
+		if *duration > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *duration)
+			defer cancel()
+		}
+		err = recordTraffic(ctx, *url, *exchange, *key, *out, *count)
+	case "replay":
+		pattern := *key
+		if pattern == "#" {
+			pattern = ""
+		}
+		err = replay(ctx, *url, *in, pattern, *toExchange, *speed, *count, *dryRun)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+}
+
+// recorder appends deliveries to the archive until it is closed
+type recorder struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	enc    *json.Encoder
+	n      int
+	limit  int
+	closed bool
+	full   chan struct{} // closed once limit messages were written
+}
+
+func (r *recorder) handle(_ context.Context, d *amqp.Delivery) pubsub.AckType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return pubsub.Requeue
+	}
+	if err := r.enc.Encode(newRecord(d)); err != nil {
+		log.Printf("❌ Failed to write %s: %v", d.RoutingKey, err)
+		return pubsub.Requeue
+	}
+	// flush every message so an interrupted recording loses nothing
+	if err := r.w.Flush(); err != nil {
+		log.Printf("❌ Failed to write %s: %v", d.RoutingKey, err)
+		return pubsub.Requeue
+	}
+	r.n++
+	if r.limit > 0 && r.n == r.limit {
+		r.closed = true
+		close(r.full)
+	}
+	return pubsub.Ack
+}
+
+func (r *recorder) close() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return r.n
+}
+
+func newRecord(d *amqp.Delivery) record {
+	rec := record{
+		Time:            time.Now().UTC(),
+		Exchange:        d.Exchange,
+		RoutingKey:      d.RoutingKey,
+		Headers:         d.Headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationID:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		MessageID:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppID:           d.AppId,
+	}
+	if strings.Contains(d.ContentType, "json") && json.Valid(d.Body) {
+		rec.JSON = d.Body
+	} else {
+		rec.Body = d.Body
+	}
+	return rec
+}
+
+// recordTraffic binds a transient queue to pattern and writes what it
+// receives until ctx is done or count messages were recorded
+func recordTraffic(ctx context.Context, url, exchange, pattern, out string, count int) error {
+	w := io.Writer(os.Stdout)
+	if out != "-" {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	r := &recorder{w: bw, enc: json.NewEncoder(bw), limit: count, full: make(chan struct{})}
+
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+	defer conn.Close()
+
+	host, _ := os.Hostname()
+	queue := fmt.Sprintf("archive.%s.%d", host, os.Getpid())
+	err = pubsub.SubscribeRaw(conn, exchange, queue, pattern, pubsub.Transient, r.handle)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s on %s: %w", pattern, exchange, err)
+	}
+	log.Printf("🎙️ Recording %s on %s to %s, Ctrl-C to stop", pattern, exchange, out)
+
+	select {
+	case <-ctx.Done():
+	case <-r.full:
+	case err := <-conn.NotifyClose(make(chan *amqp.Error, 1)):
+		n := r.close()
+		return fmt.Errorf("connection lost after %d messages: %v", n, err)
+	}
+	log.Printf("📊 Recorded %d messages", r.close())
+	return nil
+}
+
+// replay publishes the archive in order, waiting between messages as long
+// as they were apart when recorded, divided by speed
+func replay(ctx context.Context, url, in, pattern, toExchange string, speed float64, count int, dryRun bool) error {
+	r := io.Reader(os.Stdin)
+	if in != "-" {
+		f, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var ch *amqp.Channel
+	var confirms chan amqp.Confirmation
+	var nacked int
+	var confirmsDone sync.WaitGroup
+	if !dryRun {
+		conn, err := amqp.Dial(url)
+		if err != nil {
+			return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+		}
+		defer conn.Close()
+		ch, err = conn.Channel()
+		if err != nil {
+			return fmt.Errorf("failed to open channel: %w", err)
+		}
+		if err := ch.Confirm(false); err != nil {
+			return fmt.Errorf("failed to enable confirms: %w", err)
+		}
+		confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 256))
+		confirmsDone.Add(1)
+		go func() {
+			defer confirmsDone.Done()
+			for c := range confirms {
+				if !c.Ack {
+					nacked++
+				}
+			}
+		}()
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	var first time.Time
+	start := time.Now()
+	var sent, skipped, line int
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if pattern != "" && !routing.MatchKey(pattern, rec.RoutingKey) {
+			skipped++
+			continue
+		}
+		if count > 0 && sent == count {
+			break
+		}
+
+		if first.IsZero() {
+			first = rec.Time
+		}
+		if speed > 0 {
+			due := start.Add(time.Duration(float64(rec.Time.Sub(first)) / speed))
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Until(due)):
+			}
+		}
+		if ctx.Err() != nil {
+			log.Printf("🛑 Interrupted")
+			break
+		}
+
+		exchange := rec.Exchange
+		if toExchange != "" {
+			exchange = toExchange
+		}
+		if dryRun {
+			log.Printf("🔍 dry run: would send %s to %s (%s)", rec.RoutingKey, exchange, orDash(rec.MessageID))
+			sent++
+			continue
+		}
+		if err := pubsub.PublishRaw(ctx, ch, exchange, rec.RoutingKey, rec.publishing()); err != nil {
+			return fmt.Errorf("line %d: failed to publish %s: %w", line, rec.RoutingKey, err)
+		}
+		sent++
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("line %d: %w", line+1, err)
+	}
+
+	if ch != nil {
+		// closing the channel waits for outstanding confirms and then
+		// closes confirms
+		ch.Close()
+		confirmsDone.Wait()
+	}
+	log.Printf("📊 Replayed %d messages in %s, %d skipped", sent, time.Since(start).Round(time.Millisecond), skipped)
+	if nacked > 0 {
+		return fmt.Errorf("broker nacked %d messages", nacked)
+	}
+	return nil
+}
+
+func (rec record) publishing() amqp.Publishing {
+	body := []byte(rec.JSON)
+	if body == nil {
+		body = rec.Body
+	}
+	headers, _ := fromJSON(map[string]any(rec.Headers)).(amqp.Table)
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     rec.ContentType,
+		ContentEncoding: rec.ContentEncoding,
+		DeliveryMode:    rec.DeliveryMode,
+		Priority:        rec.Priority,
+		CorrelationId:   rec.CorrelationID,
+		ReplyTo:         rec.ReplyTo,
+		MessageId:       rec.MessageID,
+		Timestamp:       rec.Timestamp,
+		Type:            rec.Type,
+		AppId:           rec.AppID,
+		Body:            body,
+	}
+}
+
+// fromJSON turns decoded header values back into types an amqp.Table
+// accepts: objects become tables and whole numbers integers. Timestamps
+// come back as RFC 3339 strings, JSON has no way to tell them apart.
+func fromJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		t := amqp.Table{}
+		for k, val := range v {
+			t[k] = fromJSON(val)
+		}
+		return t
+	case []any:
+		for i, val := range v {
+			v[i] = fromJSON(val)
+		}
+		return v
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+		return v
+	}
+	return v
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,364 @@
+// cmd/dlq/main.go
+// Inspects the dead letter queue and replays, moves or purges its messages.
+//
+//	dlq list                               show every message with its x-death history
+//	dlq -reason rejected replay            send rejected messages back where they came from
+//	dlq -ids ORD-1001,ORD-1002 -to-queue orders_retry move
+//	dlq -all -dry-run purge                show what purge would drop
+//
+// Messages are fetched without acking them, so whatever is not replayed,
+// moved or purged goes back to the queue when the command exits.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/abdooman21/ecom-plat/internal/config"
+	"github.com/abdooman21/ecom-plat/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// replayHeader counts how many times a message was sent back from the DLQ
+const replayHeader = "x-dlq-replays"
+
+type filter struct {
+	ids    []string
+	key    string
+	reason string
+	origin string
+	all    bool
+}
+
+func (f filter) empty() bool {
+	return len(f.ids) == 0 && f.key == "" && f.reason == "" && f.origin == ""
+}
+
+func (f filter) match(d amqp.Delivery, death deathInfo) bool {
+	if f.all {
+		return true
+	}
+	if len(f.ids) > 0 && !slices.Contains(f.ids, d.MessageId) {
+		return false
+	}
+	if f.key != "" && !routing.MatchKey(f.key, death.routingKey) {
+		return false
+	}
+	if f.reason != "" && f.reason != death.reason {
+		return false
+	}
+	if f.origin != "" && f.origin != death.queue {
+		return false
+	}
+	return true
+}
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	logger := cfg.App.Logger()
+	slog.SetDefault(logger)
+
+	url := flag.String("url", cfg.RabbitMQ.URL, "RabbitMQ url")
+	queue := flag.String("queue", routing.DeadLetterQueue, "dead letter queue to inspect")
+	limit := flag.Int("limit", 1000, "maximum number of messages to fetch (0 for all)")
+	ids := flag.String("ids", "", "comma-separated message ids to select")
+	key := flag.String("key", "", "select messages whose original routing key matches this pattern (e.g. order.eu.*)")
+	reason := flag.String("reason", "", "select messages dead-lettered for this reason (rejected, expired, maxlen, delivery_limit)")
+	origin := flag.String("origin", "", "select messages dead-lettered from this queue")
+	all := flag.Bool("all", false, "select every message; replay, move and purge refuse to run without a selection")
+	toExchange := flag.String("to-exchange", "", "move: exchange to publish to")
+	toKey := flag.String("to-key", "", "move: routing key to publish with (defaults to the original key)")
+	toQueue := flag.String("to-queue", "", "move: queue to publish to through the default exchange")
+	dryRun := flag.Bool("dry-run", false, "show what would happen without changing anything")
+	maxBody := flag.Int("max-body", 512, "truncate printed bodies to this many bytes (0 hides them)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: dlq [flags] list|replay|move|purge\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	command := flag.Arg(0)
+
+	f := filter{key: *key, reason: *reason, origin: *origin, all: *all}
+	if *ids != "" {
+		f.ids = strings.Split(*ids, ",")
+	}
+
+	switch command {
+	case "list":
+		f.all = f.all || f.empty()
+	case "replay", "purge":
+		if !f.all && f.empty() {
+			log.Fatalf("%s needs a selection (-ids, -key, -reason, -origin) or -all", command)
+		}
+	case "move":
+		if !f.all && f.empty() {
+			log.Fatalf("move needs a selection (-ids, -key, -reason, -origin) or -all")
+		}
+		if (*toExchange == "") == (*toQueue == "") {
+			log.Fatalf("move needs exactly one of -to-exchange or -to-queue")
+		}
+		if *toQueue != "" {
+			*toKey = *toQueue
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	conn, err := amqp.Dial(*url)
+	if err != nil {
+		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		log.Fatalf("Failed to open channel: %v", err)
+	}
+	defer ch.Close()
+	if err := ch.Confirm(false); err != nil {
+		log.Fatalf("Failed to enable confirms: %v", err)
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+
+	msgs, err := fetch(ch, *queue, *limit)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", *queue, err)
+	}
+	log.Printf("📬 Fetched %d messages from %s", len(msgs), *queue)
+
+	var selected, done, failed int
+	for i, d := range msgs {
+		death := parseDeath(d)
+		if !f.match(d, death) {
+			d.Nack(false, true)
+			continue
+		}
+		selected++
+		printMessage(i+1, d, death, *maxBody)
+
+		switch {
+		case command == "list":
+			d.Nack(false, true)
+			continue
+		case *dryRun:
+			log.Printf("   🔍 dry run: would %s", describe(command, death, *toExchange, *toKey))
+			d.Nack(false, true)
+			continue
+		}
+
+		switch command {
+		case "replay":
+			err = republish(ch, returns, d, death.exchange, death.routingKey)
+		case "move":
+			target := *toKey
+			if target == "" {
+				target = death.routingKey
+			}
+			err = republish(ch, returns, d, *toExchange, target)
+		case "purge":
+			err = nil
+		}
+		if err != nil {
+			log.Printf("   ❌ %v, leaving it in %s", err, *queue)
+			d.Nack(false, true)
+			failed++
+			continue
+		}
+		d.Ack(false)
+		done++
+		log.Printf("   ✅ %s", describe(command, death, *toExchange, *toKey))
+	}
+
+	switch {
+	case command == "list" || *dryRun:
+		log.Printf("📊 %d of %d messages selected", selected, len(msgs))
+	default:
+		log.Printf("📊 %s: %d done, %d failed, %d not selected", command, done, failed, len(msgs)-selected)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// fetch gets up to limit messages without acking them. They stay unacked,
+// and therefore out of the queue, until settled or the channel closes, so
+// every message is fetched once.
+func fetch(ch *amqp.Channel, queue string, limit int) ([]amqp.Delivery, error) {
+	var msgs []amqp.Delivery
+	for limit <= 0 || len(msgs) < limit {
+		d, ok, err := ch.Get(queue, false)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		msgs = append(msgs, d)
+	}
+	return msgs, nil
+}
+
+// deathInfo is the most recent x-death entry of a message: where it was
+// and why it was dead-lettered
+type deathInfo struct {
+	queue      string
+	reason     string
+	exchange   string
+	routingKey string
+	count      int64
+	time       time.Time
+	history    int // number of x-death entries
+}
+
+func parseDeath(d amqp.Delivery) deathInfo {
+	info := deathInfo{exchange: d.Exchange, routingKey: d.RoutingKey}
+	deaths, _ := d.Headers["x-death"].([]interface{})
+	info.history = len(deaths)
+	if len(deaths) == 0 {
+		return info
+	}
+	// RabbitMQ keeps the most recent death first
+	latest, _ := deaths[0].(amqp.Table)
+	info.queue, _ = latest["queue"].(string)
+	info.reason, _ = latest["reason"].(string)
+	info.count, _ = latest["count"].(int64)
+	info.time, _ = latest["time"].(time.Time)
+	if exchange, ok := latest["exchange"].(string); ok {
+		info.exchange = exchange
+	}
+	if keys, ok := latest["routing-keys"].([]interface{}); ok && len(keys) > 0 {
+		if key, ok := keys[0].(string); ok {
+			info.routingKey = key
+		}
+	}
+	return info
+}
+
+// republish sends a copy of d and waits for the broker to confirm it was
+// routed to at least one queue
+func republish(ch *amqp.Channel, returns <-chan amqp.Return, d amqp.Delivery, exchange, key string) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	replays, _ := headers[replayHeader].(int64)
+	headers[replayHeader] = replays + 1
+
+	msg := amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Body:            d.Body,
+		// Expiration is dropped so expired messages do not expire again,
+		// and UserId because the broker rejects ids of other users
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, msg)
+	if err != nil {
+		return fmt.Errorf("publish to %s/%s: %w", exchange, key, err)
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("waiting for confirm: %w", err)
+	}
+	if !acked {
+		return errors.New("broker nacked the message")
+	}
+	// a mandatory message that was not routed is returned before its ack
+	select {
+	case r := <-returns:
+		return fmt.Errorf("no queue bound for %s/%s: %s", r.Exchange, r.RoutingKey, r.ReplyText)
+	default:
+		return nil
+	}
+}
+
+func describe(command string, death deathInfo, toExchange, toKey string) string {
+	switch command {
+	case "replay":
+		return fmt.Sprintf("replayed to %s with key %s", exchangeName(death.exchange), death.routingKey)
+	case "move":
+		if toKey == "" {
+			toKey = death.routingKey
+		}
+		return fmt.Sprintf("moved to %s with key %s", exchangeName(toExchange), toKey)
+	}
+	return "purged"
+}
+
+func exchangeName(exchange string) string {
+	if exchange == "" {
+		return "the default exchange"
+	}
+	return exchange
+}
+
+func printMessage(n int, d amqp.Delivery, death deathInfo, maxBody int) {
+	log.Printf("#%d id=%s key=%s", n, orDash(d.MessageId), death.routingKey)
+	if death.history > 0 {
+		log.Printf("   💀 queue=%s reason=%s count=%d exchange=%s at=%s (%d x-death entries)",
+			death.queue, death.reason, death.count, exchangeName(death.exchange),
+			death.time.Format(time.RFC3339), death.history)
+	} else {
+		log.Printf("   💀 no x-death header")
+	}
+	if maxBody > 0 {
+		log.Printf("   📄 %s", formatBody(d, maxBody))
+	}
+}
+
+// formatBody shows JSON compacted, text as is and anything else (e.g.
+// gob) as its size
+func formatBody(d amqp.Delivery, maxBody int) string {
+	body := d.Body
+	if strings.Contains(d.ContentType, "json") {
+		var buf bytes.Buffer
+		if json.Compact(&buf, body) == nil {
+			body = buf.Bytes()
+		}
+	} else if !utf8.Valid(body) {
+		return fmt.Sprintf("<%d bytes of %s>", len(body), orDash(d.ContentType))
+	}
+	if len(body) > maxBody {
+		return string(body[:maxBody]) + "…"
+	}
+	return string(body)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
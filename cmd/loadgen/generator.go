@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/pubsub"
+	"github.com/abdooman21/ecom-plat/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// generator publishes orders at a target rate, or as fast as its
+// publishers can, and collects the results
+type generator struct {
+	conn        *amqp.Connection
+	exchange    string
+	rate        float64
+	concurrency int
+	count       int
+	sizes       sizeRange
+	regions     regionMix
+	publisher   pubsub.PublisherConfig
+	echoWait    time.Duration
+
+	runID      string
+	replyQueue string // bound to echoExchange under its own name
+	seq        atomic.Int64
+	confirms   sync.WaitGroup
+	stats      *stats
+}
+
+func (g *generator) run(ctx context.Context) error {
+	g.runID = newRunID()
+	g.replyQueue = "loadgen.echo." + g.runID
+	g.stats = newStats(g.regions.names)
+
+	if g.echoWait > 0 {
+		err := pubsub.Subscribe(g.conn, echoExchange, g.replyQueue, g.replyQueue, pubsub.Transient,
+			g.handleEcho, pubsub.JSONUnmarshaller[Echo], pubsub.WithPrefetch(1000))
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to echoes: %w", err)
+		}
+	}
+	p, err := pubsub.NewPublisher(g.conn, g.publisher)
+	if err != nil {
+		return fmt.Errorf("failed to create publisher: %w", err)
+	}
+
+	mode := fmt.Sprintf("%.0f orders/s", g.rate)
+	if g.rate <= 0 {
+		mode = "as fast as possible"
+	}
+	log.Printf("🚀 Run %s: %s with %d publishers, payload %s, regions %s",
+		g.runID, mode, g.concurrency, g.sizes, g.regions)
+
+	var tokens chan struct{}
+	if g.rate > 0 {
+		tokens = make(chan struct{})
+		go pace(ctx, g.rate, tokens)
+	}
+
+	g.stats.start = time.Now()
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	progressDone := make(chan struct{})
+	go func() {
+		defer close(progressDone)
+		g.progress(progressCtx)
+	}()
+
+	var workers sync.WaitGroup
+	for i := 0; i < g.concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			g.publish(ctx, p, tokens)
+		}()
+	}
+	workers.Wait()
+	g.stats.publishEnd = time.Now()
+	stopProgress()
+	<-progressDone
+
+	log.Printf("⏳ Waiting for %d confirms", p.InFlight())
+	p.Close()
+	g.confirms.Wait()
+	g.stats.confirmEnd = time.Now()
+
+	if g.echoWait > 0 {
+		g.waitEchoes()
+	}
+	g.stats.report(g.echoWait > 0)
+	return nil
+}
+
+// publish sends orders until ctx is done or the count is reached, taking
+// a token per order in rate mode
+func (g *generator) publish(ctx context.Context, p *pubsub.Publisher, tokens <-chan struct{}) {
+	for {
+		if tokens != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tokens:
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+		n := g.seq.Add(1)
+		if g.count > 0 && n > int64(g.count) {
+			return
+		}
+
+		order, region := g.newOrder(n)
+		key := routing.BuildRoutingKey(region, order.ID)
+		body, err := json.Marshal(order)
+		if err != nil {
+			log.Printf("❌ Failed to encode %s: %v", order.ID, err)
+			g.stats.failed.Add(1)
+			continue
+		}
+
+		start := time.Now()
+		msg := amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			MessageId:    order.ID,
+			ReplyTo:      g.replyQueue,
+			Timestamp:    start.UTC(),
+			Headers:      amqp.Table{runHeader: g.runID, sentHeader: start.UnixNano()},
+			Body:         body,
+		}
+		future, err := p.Publish(ctx, g.exchange, key, msg)
+		if err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return
+			}
+			log.Printf("❌ Failed to publish %s: %v", order.ID, err)
+			g.stats.failed.Add(1)
+			continue
+		}
+		g.stats.sent(region, len(body))
+
+		g.confirms.Add(1)
+		go func() {
+			defer g.confirms.Done()
+			<-future.Done()
+			g.stats.confirmed(time.Since(start), future.Err())
+		}()
+	}
+}
+
+func (g *generator) newOrder(n int64) (Order, string) {
+	order := Order{
+		ID:    fmt.Sprintf("LOAD-%s-%d", g.runID, n),
+		Item:  "Load Test Item",
+		Price: float64(rand.Intn(250000)) / 100,
+	}
+	size := g.sizes.min
+	if g.sizes.max > g.sizes.min {
+		size += rand.Intn(g.sizes.max - g.sizes.min + 1)
+	}
+	// the padding field and its quotes take 13 bytes of the payload
+	base, _ := json.Marshal(order)
+	if pad := size - len(base) - 13; pad > 0 {
+		order.Padding = strings.Repeat("x", pad)
+	}
+	return order, g.regions.pick()
+}
+
+func (g *generator) handleEcho(e *Echo) pubsub.AckType {
+	if e.Run != g.runID {
+		return pubsub.Ack
+	}
+	g.stats.echoed(time.Unix(0, e.SentAt), time.Unix(0, e.ReceivedAt))
+	return pubsub.Ack
+}
+
+// waitEchoes waits until every confirmed order was echoed, or echoWait
+// passed without a new echo
+func (g *generator) waitEchoes() {
+	confirmed := g.stats.confirmedCount.Load()
+	log.Printf("⏳ Waiting for echoes of %d orders", confirmed)
+	last := g.stats.echoedCount.Load()
+	deadline := time.Now().Add(g.echoWait)
+	for time.Now().Before(deadline) {
+		n := g.stats.echoedCount.Load()
+		if n >= confirmed {
+			return
+		}
+		if n > last {
+			last = n
+			deadline = time.Now().Add(g.echoWait)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// progress logs the throughput every 5 seconds until ctx is done
+func (g *generator) progress(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	var lastSent int64
+	lastTime := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sent := g.stats.sentCount.Load()
+			rate := float64(sent-lastSent) / now.Sub(lastTime).Seconds()
+			log.Printf("📤 %d sent (%.0f/s), %d confirmed, %d echoed",
+				sent, rate, g.stats.confirmedCount.Load(), g.stats.echoedCount.Load())
+			lastSent, lastTime = sent, now
+		}
+	}
+}
+
+// pace sends rate tokens per second. It keeps to the schedule rather than
+// the interval, so a publisher that falls behind catches up.
+func pace(ctx context.Context, rate float64, tokens chan<- struct{}) {
+	interval := time.Duration(float64(time.Second) / rate)
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for n := 0; ; n++ {
+		if wait := time.Until(start.Add(time.Duration(n) * interval)); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case tokens <- struct{}{}:
+		}
+	}
+}
+
+func (s sizeRange) String() string {
+	if s.min == s.max {
+		return fmt.Sprintf("%d B", s.min)
+	}
+	return fmt.Sprintf("%d-%d B", s.min, s.max)
+}
+
+func (m regionMix) pick() string {
+	n := rand.Intn(m.total)
+	for i, w := range m.weights {
+		if n < w {
+			return m.names[i]
+		}
+		n -= w
+	}
+	return m.names[len(m.names)-1]
+}
+
+func (m regionMix) String() string {
+	parts := make([]string, len(m.names))
+	for i, name := range m.names {
+		parts[i] = fmt.Sprintf("%s=%d%%", name, m.weights[i]*100/m.total)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,263 @@
+// cmd/loadgen/main.go
+// Generates order traffic for capacity planning and reports latencies.
+//
+//	loadgen echo                                       benchmark consumer, run it first
+//	loadgen -rate 500 -duration 1m run                 500 orders per second
+//	loadgen -concurrency 16 -count 100000 run          as fast as 16 publishers can go
+//	loadgen -size 256-8192 -regions us=70,eu=30 run    vary payloads and regions
+//
+// run publishes orders through a pubsub.Publisher and times every confirm.
+// Each order carries its send time and the run's reply queue; the echo
+// command consumes the orders and sends the times back, which gives the
+// end-to-end latency. Echoes go through amq.direct rather than the order
+// exchange, so they never reach catch-all queues such as analytics_queue.
+// Without a running echo the report only covers publishing.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/config"
+	"github.com/abdooman21/ecom-plat/internal/pubsub"
+	"github.com/abdooman21/ecom-plat/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Headers set on every generated order
+const (
+	runHeader  = "x-loadgen-run"
+	sentHeader = "x-loadgen-sent" // unix nanoseconds
+)
+
+// echoExchange carries the echoes, each routed by the ReplyTo of its order
+// to the queue of the run that published it
+const echoExchange = "amq.direct"
+
+// Order matches the orders of cmd/producer_simple, padded to the payload size
+type Order struct {
+	ID      string  `json:"id"`
+	Item    string  `json:"item"`
+	Price   float64 `json:"price"`
+	Padding string  `json:"padding,omitempty"`
+}
+
+// Echo is what the benchmark consumer sends back for every order
+type Echo struct {
+	ID         string `json:"id"`
+	Run        string `json:"run"`
+	SentAt     int64  `json:"sent_at"`     // unix nanoseconds, from the order
+	ReceivedAt int64  `json:"received_at"` // unix nanoseconds, on the consumer
+}
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	logger := cfg.App.Logger()
+	slog.SetDefault(logger)
+	pubsub.SetLogger(logger)
+
+	url := flag.String("url", cfg.RabbitMQ.URL, "RabbitMQ url")
+	exchange := flag.String("exchange", routing.ExchangePerilTopic, "exchange to publish orders to")
+	rate := flag.Float64("rate", 0, "run: orders per second across all publishers (0 publishes as fast as confirms allow)")
+	concurrency := flag.Int("concurrency", 4, "run: goroutines publishing in parallel")
+	duration := flag.Duration("duration", 30*time.Second, "run: how long to publish (0 until -count or interrupted)")
+	count := flag.Int("count", 0, "run: stop after this many orders (0 for no limit)")
+	size := flag.String("size", "256", "run: payload size in bytes, or a range such as 256-8192")
+	regions := flag.String("regions", "us=40,eu=30,uk=20,asia=10", "run: regions and their weights")
+	channels := flag.Int("channels", 4, "run: confirm channels of the publisher")
+	maxInFlight := flag.Int("max-inflight", 1000, "run: unconfirmed orders before publishing blocks")
+	echoWait := flag.Duration("echo-wait", 5*time.Second, "run: how long to wait for echoes after publishing (0 skips end-to-end latency)")
+	key := flag.String("key", routing.Prod_Key, "echo: binding pattern of the orders to echo")
+	queue := flag.String("queue", "loadgen_echo", "echo: queue to consume from")
+	prefetch := flag.Int("prefetch", 500, "echo: unacked orders per consumer")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: loadgen [flags] run|echo\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	command := flag.Arg(0)
+	if command != "run" && command != "echo" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	sizes, err := parseSize(*size)
+	if err != nil {
+		log.Fatalf("Invalid -size: %v", err)
+	}
+	mix, err := parseRegions(*regions)
+	if err != nil {
+		log.Fatalf("Invalid -regions: %v", err)
+	}
+	if *concurrency < 1 {
+		log.Fatalf("-concurrency must be at least 1")
+	}
+
+	conn, err := amqp.Dial(*url)
+	if err != nil {
+		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
+	}
+	defer conn.Close()
+
+	switch command {
+	case "run":
+		g := &generator{
+			conn:        conn,
+			exchange:    *exchange,
+			rate:        *rate,
+			concurrency: *concurrency,
+			count:       *count,
+			sizes:       sizes,
+			regions:     mix,
+			publisher:   pubsub.PublisherConfig{Channels: *channels, MaxInFlight: *maxInFlight},
+			echoWait:    *echoWait,
+		}
+		if *duration > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *duration)
+			defer cancel()
+		}
+		if err := g.run(ctx); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+	case "echo":
+		if err := runEcho(ctx, conn, *exchange, *queue, *key, *prefetch); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+	}
+}
+
+// runEcho sends the send time of every generated order back to the
+// generator that published it, until ctx is done
+func runEcho(ctx context.Context, conn *amqp.Connection, exchange, queue, key string, prefetch int) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer ch.Close()
+
+	var echoed, ignored atomic.Int64
+	handler := func(ctx context.Context, d *amqp.Delivery) pubsub.AckType {
+		sent, ok := d.Headers[sentHeader].(int64)
+		if !ok || d.ReplyTo == "" {
+			// not ours, e.g. an order of cmd/producer_simple
+			ignored.Add(1)
+			return pubsub.Ack
+		}
+		run, _ := d.Headers[runHeader].(string)
+		body, _ := json.Marshal(Echo{ID: d.MessageId, Run: run, SentAt: sent, ReceivedAt: time.Now().UnixNano()})
+		err := pubsub.PublishRaw(ctx, ch, echoExchange, d.ReplyTo, amqp.Publishing{
+			ContentType:   "application/json",
+			CorrelationId: d.MessageId,
+			Body:          body,
+		})
+		if err != nil {
+			log.Printf("❌ Failed to echo %s: %v", d.MessageId, err)
+			return pubsub.Requeue
+		}
+		echoed.Add(1)
+		return pubsub.Ack
+	}
+	err = pubsub.SubscribeRaw(conn, exchange, queue, key, pubsub.Transient, handler, pubsub.WithPrefetch(prefetch))
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", key, err)
+	}
+	log.Printf("🔁 Echoing %s on %s through %s, Ctrl-C to stop", key, exchange, queue)
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("📊 Echoed %d orders, ignored %d", echoed.Load(), ignored.Load())
+			return nil
+		case <-ticker.C:
+			log.Printf("🔁 Echoed %d orders, ignored %d", echoed.Load(), ignored.Load())
+		}
+	}
+}
+
+// newRunID names a run, so echoes of an earlier run are not counted
+func newRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sizeRange is the payload size of the orders, picked uniformly
+type sizeRange struct{ min, max int }
+
+func parseSize(s string) (sizeRange, error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	min, err := strconv.Atoi(lo)
+	if err != nil {
+		return sizeRange{}, err
+	}
+	max := min
+	if isRange {
+		if max, err = strconv.Atoi(hi); err != nil {
+			return sizeRange{}, err
+		}
+	}
+	if min < 0 || max < min {
+		return sizeRange{}, fmt.Errorf("%q is not a valid size range", s)
+	}
+	return sizeRange{min, max}, nil
+}
+
+// regionMix picks regions by weight
+type regionMix struct {
+	names   []string
+	weights []int
+	total   int
+}
+
+// parseRegions reads "us=40,eu=30"; a region without a weight weighs 1
+func parseRegions(s string) (regionMix, error) {
+	var mix regionMix
+	for _, part := range strings.Split(s, ",") {
+		name, w, hasWeight := strings.Cut(strings.TrimSpace(part), "=")
+		weight := 1
+		if hasWeight {
+			var err error
+			if weight, err = strconv.Atoi(w); err != nil || weight < 0 {
+				return regionMix{}, fmt.Errorf("invalid weight for %s: %q", name, w)
+			}
+		}
+		if name == "" || strings.ContainsAny(name, ".*#") {
+			return regionMix{}, fmt.Errorf("invalid region %q", name)
+		}
+		mix.names = append(mix.names, name)
+		mix.weights = append(mix.weights, weight)
+		mix.total += weight
+	}
+	if mix.total == 0 {
+		return regionMix{}, fmt.Errorf("no region has a weight")
+	}
+	return mix, nil
+}
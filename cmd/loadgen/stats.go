@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// stats collects the outcome of a run
+type stats struct {
+	start      time.Time
+	publishEnd time.Time
+	confirmEnd time.Time
+
+	sentCount      atomic.Int64
+	confirmedCount atomic.Int64
+	echoedCount    atomic.Int64
+	nacked         atomic.Int64
+	failed         atomic.Int64
+	bytes          atomic.Int64
+	regions        map[string]*atomic.Int64
+
+	confirm   latencies // publish until the broker confirmed
+	endToEnd  latencies // publish until the benchmark consumer received it
+	roundTrip latencies // publish until its echo came back
+}
+
+func newStats(regions []string) *stats {
+	s := &stats{regions: make(map[string]*atomic.Int64, len(regions))}
+	for _, r := range regions {
+		s.regions[r] = new(atomic.Int64)
+	}
+	return s
+}
+
+func (s *stats) sent(region string, size int) {
+	s.sentCount.Add(1)
+	s.bytes.Add(int64(size))
+	s.regions[region].Add(1)
+}
+
+func (s *stats) confirmed(d time.Duration, err error) {
+	if err != nil {
+		s.nacked.Add(1)
+		return
+	}
+	s.confirmedCount.Add(1)
+	s.confirm.add(d)
+}
+
+func (s *stats) echoed(sent, received time.Time) {
+	s.echoedCount.Add(1)
+	s.endToEnd.add(received.Sub(sent))
+	s.roundTrip.add(time.Since(sent))
+}
+
+func (s *stats) report(withEcho bool) {
+	elapsed := s.publishEnd.Sub(s.start)
+	sent := s.sentCount.Load()
+	confirmed := s.confirmedCount.Load()
+
+	log.Println("📊 Load Test Report")
+	log.Printf("   Duration:    %s publishing, %s until the last confirm",
+		elapsed.Round(time.Millisecond), s.confirmEnd.Sub(s.start).Round(time.Millisecond))
+	log.Printf("   Sent:        %d orders, %s (%.0f orders/s, %s/s)",
+		sent, formatBytes(s.bytes.Load()), perSecond(sent, elapsed), formatBytes(int64(perSecond(s.bytes.Load(), elapsed))))
+	log.Printf("   Confirmed:   %d (%.0f/s), %d nacked or failed, %d not sent",
+		confirmed, perSecond(confirmed, s.confirmEnd.Sub(s.start)), s.nacked.Load(), s.failed.Load())
+
+	regions := make([]string, 0, len(s.regions))
+	for name, n := range s.regions {
+		regions = append(regions, fmt.Sprintf("%s=%d", name, n.Load()))
+	}
+	slices.Sort(regions)
+	log.Printf("   Regions:     %s", strings.Join(regions, " "))
+
+	log.Printf("   Confirm:     %s", s.confirm.summary())
+	if !withEcho {
+		return
+	}
+	echoed := s.echoedCount.Load()
+	if echoed == 0 {
+		log.Printf("   End-to-end:  no echoes, is `loadgen echo` running?")
+		return
+	}
+	log.Printf("   End-to-end:  %s", s.endToEnd.summary())
+	log.Printf("   Round trip:  %s", s.roundTrip.summary())
+	if echoed < confirmed {
+		log.Printf("   ⚠️  %d of %d confirmed orders were not echoed", confirmed-echoed, confirmed)
+	}
+}
+
+// latencies keeps every sample, which is fine for the few million
+// messages of a run and gives exact percentiles
+type latencies struct {
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mu.Lock()
+	l.samples = append(l.samples, d)
+	l.mu.Unlock()
+}
+
+func (l *latencies) summary() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) == 0 {
+		return "no samples"
+	}
+	slices.Sort(l.samples)
+	var sum time.Duration
+	for _, d := range l.samples {
+		sum += d
+	}
+	return fmt.Sprintf("p50=%s p90=%s p99=%s p99.9=%s max=%s avg=%s",
+		round(l.percentile(0.50)), round(l.percentile(0.90)), round(l.percentile(0.99)),
+		round(l.percentile(0.999)), round(l.samples[len(l.samples)-1]), round(sum/time.Duration(len(l.samples))))
+}
+
+// percentile expects the samples to be sorted
+func (l *latencies) percentile(p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(l.samples)))) - 1
+	return l.samples[max(i, 0)]
+}
+
+func round(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(100 * time.Microsecond)
+}
+
+func perSecond(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds()
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,74 @@
+// cmd/topology/main.go
+// Compares the declared exchanges, queues and bindings with what the
+// broker actually has, using the RabbitMQ management API.
+// Exits with status 1 when drift is found so it can gate deployments.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/config"
+	"github.com/abdooman21/ecom-plat/internal/management"
+	"github.com/abdooman21/ecom-plat/internal/topology"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	logger := cfg.App.Logger()
+	slog.SetDefault(logger)
+
+	specPath := flag.String("spec", "", "path to a JSON topology spec (defaults to the standard routing configs)")
+	mgmtURL := flag.String("url", cfg.RabbitMQ.ManagementURL, "RabbitMQ management API url")
+	vhost := flag.String("vhost", "/", "virtual host to inspect")
+	ignoreExtra := flag.Bool("ignore-extra", false, "do not report objects missing from the spec")
+	flag.Parse()
+
+	spec := topology.Standard()
+	if *specPath != "" {
+		spec, err = topology.LoadFile(*specPath)
+		if err != nil {
+			log.Fatalf("Failed to load spec: %v", err)
+		}
+	}
+
+	client, err := management.New(*mgmtURL)
+	if err != nil {
+		log.Fatalf("Failed to create management client: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	actual, err := topology.Fetch(ctx, client, *vhost)
+	if err != nil {
+		log.Fatalf("Failed to fetch broker topology: %v", err)
+	}
+
+	report := topology.Diff(spec, actual)
+	drifted := false
+	for _, d := range report.Drifts {
+		if *ignoreExtra && d.Kind == topology.Extra {
+			continue
+		}
+		drifted = true
+		log.Printf("⚠️  %s", d)
+	}
+
+	log.Printf("📊 missing=%d extra=%d mismatched=%d",
+		report.Count(topology.Missing), report.Count(topology.Extra), report.Count(topology.Mismatched))
+
+	if drifted {
+		log.Println("❌ Topology drift detected")
+		os.Exit(1)
+	}
+	log.Println("✅ Broker topology matches the spec")
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "defaults"},
+		{name: "levels are case insensitive", env: map[string]string{"LOG_LEVEL": "WARN", "LOG_FORMAT": "JSON"}},
+		{name: "unknown log level", env: map[string]string{"LOG_LEVEL": "warning"}, wantErr: `LOG_LEVEL must be debug, info, warn or error, got "warning"`},
+		{name: "unknown log format", env: map[string]string{"LOG_FORMAT": "logfmt"}, wantErr: `LOG_FORMAT must be text or json, got "logfmt"`},
+		{name: "sample ratio above 1", env: map[string]string{"OTEL_TRACES_SAMPLER_ARG": "10"}, wantErr: "OTEL_TRACES_SAMPLER_ARG must be between 0 and 1"},
+		{name: "no prefetch", env: map[string]string{"RABBITMQ_PREFETCH_COUNT": "0"}, wantErr: "RABBITMQ_PREFETCH_COUNT must be at least 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"LOG_LEVEL", "LOG_FORMAT", "OTEL_TRACES_SAMPLER_ARG", "RABBITMQ_PREFETCH_COUNT"} {
+				t.Setenv(key, tt.env[key])
+			}
+			cfg, err := Load()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load: %v", err)
+				}
+				if cfg.Tracing.SampleRatio != 1 {
+					t.Errorf("SampleRatio = %v, want 1", cfg.Tracing.SampleRatio)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
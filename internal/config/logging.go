@@ -0,0 +1,55 @@
+// internal/config/logging.go
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// NewLogger builds a logger writing to stderr at level (debug, info, warn
+// or error) in format "text" or "json". It does not fail: an unknown
+// level means info and an unknown format text, so check them with
+// AppConfig.Validate first.
+func NewLogger(level, format string) *slog.Logger {
+	lvl, err := parseLevel(level)
+	if err != nil {
+		lvl = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var h slog.Handler
+	if strings.EqualFold(format, "json") {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		h = slog.NewTextHandler(os.Stderr, opts)
+	}
+	return slog.New(h)
+}
+
+// Logger builds the service logger from LOG_LEVEL and LOG_FORMAT, tagged
+// with the service name
+func (a AppConfig) Logger() *slog.Logger {
+	return NewLogger(a.LogLevel, a.LogFormat).With(slog.String("service", a.ServiceName))
+}
+
+// Validate rejects log levels and formats NewLogger does not know, so a
+// typo such as LOG_LEVEL=warning does not go unnoticed
+func (a AppConfig) Validate() error {
+	if _, err := parseLevel(a.LogLevel); err != nil {
+		return err
+	}
+	if f := strings.ToLower(a.LogFormat); f != "text" && f != "json" {
+		return fmt.Errorf("LOG_FORMAT must be text or json, got %q", a.LogFormat)
+	}
+	return nil
+}
+
+func parseLevel(level string) (slog.Level, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got %q", level)
+	}
+	return lvl, nil
+}
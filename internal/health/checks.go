@@ -0,0 +1,62 @@
+// internal/health/checks.go
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/pubsub"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Connection fails once the RabbitMQ connection is closed
+func Connection(conn *amqp.Connection) Check {
+	return func(context.Context) error {
+		if conn.IsClosed() {
+			return errors.New("connection to RabbitMQ is closed")
+		}
+		return nil
+	}
+}
+
+// Channel fails once ch is closed, e.g. after a channel-level error
+func Channel(ch *amqp.Channel) Check {
+	return func(context.Context) error {
+		if ch.IsClosed() {
+			return errors.New("channel is closed")
+		}
+		return nil
+	}
+}
+
+// Subscriptions fails when any subscription stopped consuming without
+// being paused or closed on purpose
+func Subscriptions(subs ...*pubsub.Subscription) Check {
+	return func(context.Context) error {
+		var errs []error
+		for _, s := range subs {
+			if err := s.Err(); err != nil && !errors.Is(err, pubsub.ErrSubscriptionClosed) {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// Publishing fails when publishes have been failing and none succeeded
+// within maxAge. A producer that has not published yet, or whose last
+// publish succeeded, is healthy however long ago that was.
+func Publishing(maxAge time.Duration) Check {
+	return func(context.Context) error {
+		ok, failed := pubsub.LastPublish()
+		if failed.After(ok) && time.Since(ok) > maxAge {
+			if ok.IsZero() {
+				return fmt.Errorf("no publish has succeeded yet, last failure at %s", failed.Format(time.RFC3339))
+			}
+			return fmt.Errorf("no successful publish since %s", ok.Format(time.RFC3339))
+		}
+		return nil
+	}
+}
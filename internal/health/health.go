@@ -0,0 +1,150 @@
+// internal/health/health.go
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Check returns nil when the component it watches is healthy
+type Check func(ctx context.Context) error
+
+// Server answers Kubernetes probes: /healthz runs the liveness checks and
+// /readyz the readiness checks. Each returns 200 when all checks pass and
+// 503 otherwise, with the result of every check as JSON.
+type Server struct {
+	// Timeout bounds each probe. The checks run in parallel, and one that
+	// has not returned by then fails, whether or not it honours its ctx.
+	Timeout time.Duration
+
+	mu        sync.RWMutex
+	liveness  map[string]Check
+	readiness map[string]Check
+}
+
+// Report is the body of a probe response
+type Report struct {
+	Status string            `json:"status"` // "ok" or "unavailable"
+	Checks map[string]string `json:"checks"` // "ok" or the error
+}
+
+func New() *Server {
+	return &Server{
+		Timeout:   2 * time.Second,
+		liveness:  make(map[string]Check),
+		readiness: make(map[string]Check),
+	}
+}
+
+// AddLiveness registers a check whose failure means the process must be
+// restarted, e.g. a lost connection the code does not re-establish
+func (s *Server) AddLiveness(name string, check Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.liveness[name] = check
+}
+
+// AddReadiness registers a check whose failure means the process cannot
+// do its work right now
+func (s *Server) AddReadiness(name string, check Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readiness[name] = check
+}
+
+// Live runs the liveness checks
+func (s *Server) Live(ctx context.Context) Report {
+	return s.run(ctx, func() map[string]Check { return s.liveness })
+}
+
+// Ready runs the readiness checks. A process that is not live is not
+// ready either, so the liveness checks run too.
+func (s *Server) Ready(ctx context.Context) Report {
+	return s.run(ctx, func() map[string]Check {
+		all := make(map[string]Check, len(s.liveness)+len(s.readiness))
+		for name, c := range s.liveness {
+			all[name] = c
+		}
+		for name, c := range s.readiness {
+			all[name] = c
+		}
+		return all
+	})
+}
+
+func (s *Server) run(ctx context.Context, checks func() map[string]Check) Report {
+	s.mu.RLock()
+	snapshot := checks()
+	names := make([]string, 0, len(snapshot))
+	for name := range snapshot {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+	sort.Strings(names)
+
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	type result struct {
+		name string
+		err  error
+	}
+	// buffered so a check still running after the timeout can finish
+	// without anyone reading its result
+	results := make(chan result, len(names))
+	for _, name := range names {
+		go func() {
+			results <- result{name, snapshot[name](ctx)}
+		}()
+	}
+
+	report := Report{Status: "ok", Checks: make(map[string]string, len(names))}
+	for range names {
+		select {
+		case r := <-results:
+			report.Checks[r.name] = "ok"
+			if r.err != nil {
+				report.Status = "unavailable"
+				report.Checks[r.name] = r.err.Error()
+			}
+		case <-ctx.Done():
+			report.Status = "unavailable"
+			for _, name := range names {
+				if _, done := report.Checks[name]; !done {
+					report.Checks[name] = "check did not finish: " + ctx.Err().Error()
+				}
+			}
+			return report
+		}
+	}
+	return report
+}
+
+// Handler serves /healthz and /readyz
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, s.Live(r.Context()))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, s.Ready(r.Context()))
+	})
+	return mux
+}
+
+// ListenAndServe serves the probes on addr
+func (s *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s.Handler())
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
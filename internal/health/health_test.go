@@ -0,0 +1,154 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ok(context.Context) error { return nil }
+
+func failing(context.Context) error { return errors.New("queue gone") }
+
+// stuck ignores its ctx, like a check blocked on a lock
+func stuck(release chan struct{}) Check {
+	return func(context.Context) error {
+		<-release
+		return nil
+	}
+}
+
+// slow honours its ctx
+func slow(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Minute):
+		return nil
+	}
+}
+
+func TestServerChecks(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	tests := []struct {
+		name       string
+		liveness   map[string]Check
+		readiness  map[string]Check
+		wantLive   string
+		wantReady  string
+		wantChecks map[string]string // of the readiness report, by prefix
+	}{
+		{
+			name:       "all ok",
+			liveness:   map[string]Check{"conn": ok},
+			readiness:  map[string]Check{"subs": ok},
+			wantLive:   "ok",
+			wantReady:  "ok",
+			wantChecks: map[string]string{"conn": "ok", "subs": "ok"},
+		},
+		{
+			name:       "readiness failure",
+			liveness:   map[string]Check{"conn": ok},
+			readiness:  map[string]Check{"subs": failing},
+			wantLive:   "ok",
+			wantReady:  "unavailable",
+			wantChecks: map[string]string{"conn": "ok", "subs": "queue gone"},
+		},
+		{
+			name:       "liveness failure is not ready either",
+			liveness:   map[string]Check{"conn": failing},
+			readiness:  map[string]Check{"subs": ok},
+			wantLive:   "unavailable",
+			wantReady:  "unavailable",
+			wantChecks: map[string]string{"conn": "queue gone", "subs": "ok"},
+		},
+		{
+			name:       "check ignoring its ctx times out",
+			liveness:   map[string]Check{"conn": ok},
+			readiness:  map[string]Check{"lock": stuck(release)},
+			wantLive:   "ok",
+			wantReady:  "unavailable",
+			wantChecks: map[string]string{"conn": "ok", "lock": "check did not finish"},
+		},
+		{
+			name:       "check honouring its ctx times out",
+			liveness:   map[string]Check{"conn": ok},
+			readiness:  map[string]Check{"broker": slow},
+			wantLive:   "ok",
+			wantReady:  "unavailable",
+			wantChecks: map[string]string{"conn": "ok", "broker": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New()
+			s.Timeout = 50 * time.Millisecond
+			for name, c := range tt.liveness {
+				s.AddLiveness(name, c)
+			}
+			for name, c := range tt.readiness {
+				s.AddReadiness(name, c)
+			}
+
+			if live := s.Live(context.Background()); live.Status != tt.wantLive {
+				t.Errorf("Live = %+v, want %s", live, tt.wantLive)
+			}
+			start := time.Now()
+			ready := s.Ready(context.Background())
+			if took := time.Since(start); took > time.Second {
+				t.Errorf("Ready took %v with a 50ms timeout", took)
+			}
+			if ready.Status != tt.wantReady {
+				t.Errorf("Ready = %+v, want %s", ready, tt.wantReady)
+			}
+			if len(ready.Checks) != len(tt.wantChecks) {
+				t.Errorf("Ready checks = %v, want %v", ready.Checks, tt.wantChecks)
+			}
+			for name, want := range tt.wantChecks {
+				if got, found := ready.Checks[name]; !found || !strings.HasPrefix(got, want) {
+					t.Errorf("check %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	s := New()
+	s.AddLiveness("conn", ok)
+	s.AddReadiness("subs", failing)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		want   string
+	}{
+		{"/healthz", http.StatusOK, "ok"},
+		{"/readyz", http.StatusServiceUnavailable, "unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + tt.path)
+			if err != nil {
+				t.Fatalf("GET: %v", err)
+			}
+			defer resp.Body.Close()
+			var report Report
+			if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if resp.StatusCode != tt.status || report.Status != tt.want {
+				t.Errorf("GET %s = %d %+v, want %d %s", tt.path, resp.StatusCode, report, tt.status, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+// internal/inbox/memory.go
+package inbox
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/pubsub"
+)
+
+// MemoryStore is an in-process pubsub.IdempotencyStore that keeps at most
+// Capacity keys, evicting the least recently used one, and forgets keys
+// after TTL. It only deduplicates within a single consumer instance.
+type MemoryStore struct {
+	// ClaimTimeout is how long a claimed key waits for its outcome before
+	// another delivery may claim it again
+	ClaimTimeout time.Duration
+
+	capacity int
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	order *list.List // front is the most recently used
+	items map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	settled bool      // false while the key is only claimed
+	expires time.Time // of the outcome, or of the claim until settled
+}
+
+func (e *memoryEntry) expired(now time.Time, ttl time.Duration) bool {
+	if e.settled && ttl <= 0 {
+		return false
+	}
+	return now.After(e.expires)
+}
+
+// NewMemoryStore creates a store holding up to capacity keys for ttl.
+// A zero ttl keeps keys until they are evicted.
+func NewMemoryStore(capacity int, ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		ClaimTimeout: 5 * time.Minute,
+		capacity:     capacity,
+		ttl:          ttl,
+		order:        list.New(),
+		items:        make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryStore) Claim(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if el, ok := s.items[key]; ok {
+		entry := el.Value.(*memoryEntry)
+		s.order.MoveToFront(el)
+		if !entry.expired(now, s.ttl) {
+			return false, nil
+		}
+		entry.settled, entry.expires = false, now.Add(s.ClaimTimeout)
+		return true, nil
+	}
+	s.add(&memoryEntry{key: key, expires: now.Add(s.ClaimTimeout)})
+	return true, nil
+}
+
+// Put marks a claimed key processed; the store only needs to know that it
+// was, not how
+func (s *MemoryStore) Put(key string, _ pubsub.AckType) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires := time.Now().Add(s.ttl)
+	if el, ok := s.items[key]; ok {
+		entry := el.Value.(*memoryEntry)
+		entry.settled, entry.expires = true, expires
+		s.order.MoveToFront(el)
+		return nil
+	}
+	// the claim was evicted while the message was processed
+	s.add(&memoryEntry{key: key, settled: true, expires: expires})
+	return nil
+}
+
+func (s *MemoryStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok && !el.Value.(*memoryEntry).settled {
+		s.order.Remove(el)
+		delete(s.items, key)
+	}
+	return nil
+}
+
+// add inserts a new entry and evicts the least recently used ones over
+// capacity
+func (s *MemoryStore) add(entry *memoryEntry) {
+	s.items[entry.key] = s.order.PushFront(entry)
+	for s.capacity > 0 && s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.items, oldest.Value.(*memoryEntry).key)
+	}
+}
+
+// Len returns the number of keys currently held
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
@@ -0,0 +1,106 @@
+// internal/inbox/sqlite.go
+package inbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/pubsub"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS inbox (
+	message_key  TEXT    PRIMARY KEY,
+	outcome      INTEGER NOT NULL,
+	processed_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS inbox_processed_at ON inbox (processed_at);
+`
+
+// claimed is the outcome of a key that is claimed but not settled yet;
+// its processed_at is when it was claimed
+const claimed = -1
+
+// SQLiteStore is a pubsub.IdempotencyStore kept in an "inbox" table of a
+// SQLite database, so deduplication survives restarts and can be shared
+// by consumers on the same host. The caller opens the *sql.DB with the
+// driver of their choice (e.g. modernc.org/sqlite).
+type SQLiteStore struct {
+	// ClaimTimeout is how long a claimed key waits for its outcome before
+	// another delivery may claim it again, e.g. after a consumer crashed
+	// while processing it
+	ClaimTimeout time.Duration
+
+	db  *sql.DB
+	ttl time.Duration
+}
+
+// NewSQLiteStore creates the inbox table if needed. Keys older than ttl
+// are ignored by Get and removed by Purge; a zero ttl keeps them forever.
+func NewSQLiteStore(ctx context.Context, db *sql.DB, ttl time.Duration) (*SQLiteStore, error) {
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		return nil, fmt.Errorf("failed to create inbox table: %w", err)
+	}
+	return &SQLiteStore{ClaimTimeout: 5 * time.Minute, db: db, ttl: ttl}, nil
+}
+
+// Claim inserts the key, or takes over a row whose claim timed out or
+// whose outcome is older than the ttl, in a single statement so that
+// concurrent claims of the same key cannot both succeed
+func (s *SQLiteStore) Claim(key string) (bool, error) {
+	now := time.Now().UTC()
+	expired := int64(math.MinInt64)
+	if s.ttl > 0 {
+		expired = now.Add(-s.ttl).UnixNano()
+	}
+	res, err := s.db.Exec(
+		`INSERT INTO inbox (message_key, outcome, processed_at) VALUES (?, ?, ?)
+		 ON CONFLICT (message_key) DO UPDATE SET outcome = excluded.outcome, processed_at = excluded.processed_at
+		 WHERE (inbox.outcome = ? AND inbox.processed_at < ?) OR (inbox.outcome <> ? AND inbox.processed_at < ?)`,
+		key, claimed, now.UnixNano(),
+		claimed, now.Add(-s.ClaimTimeout).UnixNano(), claimed, expired,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim inbox key %s: %w", key, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim inbox key %s: %w", key, err)
+	}
+	return n == 1, nil
+}
+
+func (s *SQLiteStore) Put(key string, outcome pubsub.AckType) error {
+	_, err := s.db.Exec(
+		`INSERT INTO inbox (message_key, outcome, processed_at) VALUES (?, ?, ?)
+		 ON CONFLICT (message_key) DO UPDATE SET outcome = excluded.outcome, processed_at = excluded.processed_at`,
+		key, outcome, time.Now().UTC().UnixNano(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record inbox key %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Release(key string) error {
+	_, err := s.db.Exec(`DELETE FROM inbox WHERE message_key = ? AND outcome = ?`, key, claimed)
+	if err != nil {
+		return fmt.Errorf("failed to release inbox key %s: %w", key, err)
+	}
+	return nil
+}
+
+// Purge deletes keys older than the store's ttl
+func (s *SQLiteStore) Purge(ctx context.Context) (int64, error) {
+	if s.ttl <= 0 {
+		return 0, nil
+	}
+	res, err := s.db.ExecContext(ctx, `DELETE FROM inbox WHERE processed_at < ?`, time.Now().Add(-s.ttl).UTC().UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge inbox: %w", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,229 @@
+package inbox
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/pubsub"
+	_ "modernc.org/sqlite"
+)
+
+// store is what the tests need of both stores
+type store interface {
+	pubsub.IdempotencyStore
+}
+
+// stores opens each kind of store with the given ttl and claim timeout
+var stores = map[string]func(t *testing.T, ttl, claimTimeout time.Duration) store{
+	"memory": func(_ *testing.T, ttl, claimTimeout time.Duration) store {
+		s := NewMemoryStore(100, ttl)
+		s.ClaimTimeout = claimTimeout
+		return s
+	},
+	"sqlite": func(t *testing.T, ttl, claimTimeout time.Duration) store {
+		s, err := NewSQLiteStore(context.Background(), openSQLite(t), ttl)
+		if err != nil {
+			t.Fatalf("NewSQLiteStore: %v", err)
+		}
+		s.ClaimTimeout = claimTimeout
+		return s
+	},
+}
+
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "inbox.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// step is one call on a store; wait sleeps first
+type step struct {
+	wait time.Duration
+	op   string // claim, put or release
+	key  string
+	want bool // of a claim
+}
+
+func TestStores(t *testing.T) {
+	const short = 30 * time.Millisecond
+	tests := []struct {
+		name         string
+		ttl          time.Duration
+		claimTimeout time.Duration
+		steps        []step
+	}{
+		{
+			name:         "processed key is not claimed again",
+			claimTimeout: time.Minute,
+			steps: []step{
+				{op: "claim", key: "m-1", want: true},
+				{op: "put", key: "m-1"},
+				{op: "claim", key: "m-1", want: false},
+				{op: "claim", key: "m-2", want: true},
+			},
+		},
+		{
+			name:         "claimed key is not claimed twice",
+			claimTimeout: time.Minute,
+			steps: []step{
+				{op: "claim", key: "m-1", want: true},
+				{op: "claim", key: "m-1", want: false},
+			},
+		},
+		{
+			name:         "released key is claimed again",
+			claimTimeout: time.Minute,
+			steps: []step{
+				{op: "claim", key: "m-1", want: true},
+				{op: "release", key: "m-1"},
+				{op: "claim", key: "m-1", want: true},
+			},
+		},
+		{
+			name:         "release keeps a processed key",
+			claimTimeout: time.Minute,
+			steps: []step{
+				{op: "claim", key: "m-1", want: true},
+				{op: "put", key: "m-1"},
+				{op: "release", key: "m-1"},
+				{op: "claim", key: "m-1", want: false},
+			},
+		},
+		{
+			name:         "abandoned claim times out",
+			claimTimeout: short,
+			steps: []step{
+				{op: "claim", key: "m-1", want: true},
+				{op: "claim", key: "m-1", want: false},
+				{wait: 2 * short, op: "claim", key: "m-1", want: true},
+			},
+		},
+		{
+			name:         "processed key expires after the ttl",
+			ttl:          short,
+			claimTimeout: time.Minute,
+			steps: []step{
+				{op: "claim", key: "m-1", want: true},
+				{op: "put", key: "m-1"},
+				{op: "claim", key: "m-1", want: false},
+				{wait: 2 * short, op: "claim", key: "m-1", want: true},
+			},
+		},
+		{
+			name:         "zero ttl keeps processed keys",
+			claimTimeout: short,
+			steps: []step{
+				{op: "claim", key: "m-1", want: true},
+				{op: "put", key: "m-1"},
+				{wait: 2 * short, op: "claim", key: "m-1", want: false},
+			},
+		},
+	}
+	for kind, open := range stores {
+		for _, tt := range tests {
+			t.Run(kind+"/"+tt.name, func(t *testing.T) {
+				s := open(t, tt.ttl, tt.claimTimeout)
+				for i, st := range tt.steps {
+					time.Sleep(st.wait)
+					var err error
+					switch st.op {
+					case "claim":
+						var got bool
+						got, err = s.Claim(st.key)
+						if err == nil && got != st.want {
+							t.Fatalf("step %d: Claim(%s) = %v, want %v", i, st.key, got, st.want)
+						}
+					case "put":
+						err = s.Put(st.key, pubsub.Ack)
+					case "release":
+						err = s.Release(st.key)
+					}
+					if err != nil {
+						t.Fatalf("step %d: %s(%s): %v", i, st.op, st.key, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestStoresConcurrentClaims(t *testing.T) {
+	for kind, open := range stores {
+		t.Run(kind, func(t *testing.T) {
+			s := open(t, time.Hour, time.Minute)
+			var won atomic.Int32
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					ok, err := s.Claim("m-1")
+					if err != nil {
+						t.Errorf("Claim: %v", err)
+					}
+					if ok {
+						won.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+			if n := won.Load(); n != 1 {
+				t.Errorf("%d concurrent claims of one key succeeded", n)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	s := NewMemoryStore(3, 0)
+	for _, key := range []string{"a", "b", "c"} {
+		s.Claim(key)
+		s.Put(key, pubsub.Ack)
+	}
+	// a claim attempt counts as a use, so b is now the oldest
+	if ok, _ := s.Claim("a"); ok {
+		t.Fatal("a was claimed twice")
+	}
+	s.Claim("d")
+	if n := s.Len(); n != 3 {
+		t.Errorf("Len = %d, want the capacity of 3", n)
+	}
+	// b last, as claiming it evicts another key
+	for _, tt := range []struct {
+		key  string
+		want bool
+	}{{"a", false}, {"c", false}, {"d", false}, {"b", true}} {
+		if ok, _ := s.Claim(tt.key); ok != tt.want {
+			t.Errorf("Claim(%s) = %v, want %v", tt.key, ok, tt.want)
+		}
+	}
+}
+
+func TestSQLiteStorePurge(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewSQLiteStore(ctx, openSQLite(t), 30*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	s.Claim("old")
+	s.Put("old", pubsub.Discard)
+	time.Sleep(60 * time.Millisecond)
+	s.Claim("new")
+	s.Put("new", pubsub.Ack)
+
+	if n, err := s.Purge(ctx); err != nil || n != 1 {
+		t.Errorf("Purge = %d, %v; want 1", n, err)
+	}
+	if ok, _ := s.Claim("new"); ok {
+		t.Error("Purge removed a key younger than the ttl")
+	}
+}
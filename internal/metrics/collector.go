@@ -0,0 +1,450 @@
+// internal/metrics/collector.go
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Kind is the Prometheus type of a metric family
+type Kind string
+
+const (
+	KindCounter   Kind = "counter"
+	KindGauge     Kind = "gauge"
+	KindHistogram Kind = "histogram"
+	KindSummary   Kind = "summary" // sum and count only, no quantiles
+)
+
+// DefaultBuckets are latency buckets in seconds, from 1ms to 10s
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metric families and renders them in the Prometheus text
+// format. Registering a name twice returns the existing family.
+//
+// Metrics must never take down the code they observe: an invalid name, a
+// name registered again with another type or labels, or the wrong number
+// of label values is logged once and the samples are dropped.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+	rejected map[string]bool // names already logged as invalid
+}
+
+// Default is the registry the pubsub package reports to and Handler serves
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family), rejected: make(map[string]bool)}
+}
+
+var (
+	metricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelName  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+type family struct {
+	name       string
+	help       string
+	kind       Kind
+	labelNames []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+	fn     func() float64 // gauge funcs only
+
+	warned atomic.Bool // a label count mismatch was logged
+}
+
+type series struct {
+	labelValues []string
+
+	mu     sync.Mutex
+	value  float64  // counter and gauge value
+	counts []uint64 // histogram bucket counts (not cumulative)
+	sum    float64  // histogram and summary
+	count  uint64   // histogram and summary
+}
+
+// register returns the family name, creating it if needed. It returns
+// nil, after logging why once per name, when the family cannot be used.
+func (r *Registry) register(name, help string, kind Kind, buckets []float64, labelNames []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, err := r.lookup(name, kind, labelNames)
+	if err != nil {
+		if !r.rejected[name] {
+			r.rejected[name] = true
+			slog.Error("metrics: dropping samples", "metric", name, "error", err)
+		}
+		return nil
+	}
+	if f != nil {
+		return f
+	}
+	f = &family{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+// lookup validates a registration and returns the existing family, if
+// any; must be called with mu held
+func (r *Registry) lookup(name string, kind Kind, labelNames []string) (*family, error) {
+	if !metricName.MatchString(name) {
+		return nil, fmt.Errorf("invalid metric name %q", name)
+	}
+	for _, l := range labelNames {
+		if !labelName.MatchString(l) || strings.HasPrefix(l, "__") || (kind == KindHistogram && l == "le") {
+			return nil, fmt.Errorf("invalid label name %q", l)
+		}
+	}
+	f, ok := r.families[name]
+	if !ok {
+		return nil, nil
+	}
+	if f.kind != kind || !slices.Equal(f.labelNames, labelNames) {
+		return nil, fmt.Errorf("already registered as %s%v, not %s%v", f.kind, f.labelNames, kind, labelNames)
+	}
+	return f, nil
+}
+
+// with returns the series of labelValues, or nil when the family was
+// rejected or the number of values is wrong
+func (f *family) with(labelValues []string) *series {
+	if f == nil {
+		return nil
+	}
+	if len(labelValues) != len(f.labelNames) {
+		if !f.warned.Swap(true) {
+			slog.Error("metrics: dropping samples with the wrong number of label values",
+				"metric", f.name, "want", len(f.labelNames), "got", len(labelValues))
+		}
+		return nil
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.kind == KindHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// CounterVec is a counter family partitioned by labels
+type CounterVec struct{ f *family }
+
+// Counter is a monotonically increasing value
+type Counter struct{ s *series }
+
+// NewCounterVec registers a counter family
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, KindCounter, nil, labelNames)}
+}
+
+// With returns the counter for the given label values
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return &Counter{v.f.with(labelValues)}
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(delta float64) {
+	if c.s == nil || delta < 0 {
+		return
+	}
+	c.s.mu.Lock()
+	c.s.value += delta
+	c.s.mu.Unlock()
+}
+
+// GaugeVec is a gauge family partitioned by labels
+type GaugeVec struct{ f *family }
+
+// Gauge is a value that can go up and down
+type Gauge struct{ s *series }
+
+// NewGaugeVec registers a gauge family
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, KindGauge, nil, labelNames)}
+}
+
+// NewGaugeFunc registers an unlabelled gauge whose value is read from fn
+// at scrape time
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	f := r.register(name, help, KindGauge, nil, nil)
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	f.fn = fn
+	f.mu.Unlock()
+}
+
+// With returns the gauge for the given label values
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	return &Gauge{v.f.with(labelValues)}
+}
+
+func (g *Gauge) Set(value float64) {
+	if g.s == nil {
+		return
+	}
+	g.s.mu.Lock()
+	g.s.value = value
+	g.s.mu.Unlock()
+}
+
+func (g *Gauge) Add(delta float64) {
+	if g.s == nil {
+		return
+	}
+	g.s.mu.Lock()
+	g.s.value += delta
+	g.s.mu.Unlock()
+}
+
+// HistogramVec is a histogram family partitioned by labels
+type HistogramVec struct{ f *family }
+
+// Histogram counts observations into buckets
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+// NewHistogramVec registers a histogram family; nil buckets use DefaultBuckets
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &HistogramVec{r.register(name, help, KindHistogram, buckets, labelNames)}
+}
+
+// With returns the histogram for the given label values
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	s := v.f.with(labelValues)
+	if s == nil {
+		return &Histogram{}
+	}
+	return &Histogram{s: s, buckets: v.f.buckets}
+}
+
+func (h *Histogram) Observe(value float64) {
+	if h.s == nil {
+		return
+	}
+	i := sort.SearchFloat64s(h.buckets, value) // first bucket with le >= value
+	h.s.mu.Lock()
+	if i < len(h.s.counts) {
+		h.s.counts[i]++
+	}
+	h.s.sum += value
+	h.s.count++
+	h.s.mu.Unlock()
+}
+
+// SummaryVec is a summary family partitioned by labels. It only tracks
+// the sum and count of observations, which is enough for totals and averages.
+type SummaryVec struct{ f *family }
+
+// Summary accumulates observations
+type Summary struct{ s *series }
+
+// NewSummaryVec registers a summary family
+func (r *Registry) NewSummaryVec(name, help string, labelNames ...string) *SummaryVec {
+	return &SummaryVec{r.register(name, help, KindSummary, nil, labelNames)}
+}
+
+// With returns the summary for the given label values
+func (v *SummaryVec) With(labelValues ...string) *Summary {
+	return &Summary{v.f.with(labelValues)}
+}
+
+func (m *Summary) Observe(value float64) {
+	if m.s == nil {
+		return
+	}
+	m.s.mu.Lock()
+	m.s.sum += value
+	m.s.count++
+	m.s.mu.Unlock()
+}
+
+// Sample is the current state of one series
+type Sample struct {
+	Name   string
+	Labels string // rendered as {a="x",b="y"}, empty when unlabelled
+	Kind   Kind
+	Value  float64 // counters and gauges
+	Sum    float64 // histograms and summaries
+	Count  uint64  // histograms and summaries
+}
+
+// Gather returns a snapshot of every series, sorted by name and labels
+func (r *Registry) Gather() []Sample {
+	var samples []Sample
+	for _, f := range r.sorted() {
+		samples = append(samples, f.gather()...)
+	}
+	return samples
+}
+
+func (r *Registry) sorted() []*family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+	return families
+}
+
+func (f *family) gather() []Sample {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fn != nil {
+		return []Sample{{Name: f.name, Kind: f.kind, Value: f.fn()}}
+	}
+	samples := make([]Sample, 0, len(f.series))
+	for _, k := range f.sortedKeys() {
+		s := f.series[k]
+		s.mu.Lock()
+		samples = append(samples, Sample{
+			Name:   f.name,
+			Labels: labelString(f.labelNames, s.labelValues),
+			Kind:   f.kind,
+			Value:  s.value,
+			Sum:    s.sum,
+			Count:  s.count,
+		})
+		s.mu.Unlock()
+	}
+	return samples
+}
+
+// sortedKeys must be called with mu held
+func (f *family) sortedKeys() []string {
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WritePrometheus renders every family in the Prometheus text exposition format
+func (r *Registry) WritePrometheus(w io.Writer) {
+	for _, f := range r.sorted() {
+		f.write(w)
+	}
+}
+
+func (f *family) write(w io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fn == nil && len(f.series) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	if f.fn != nil {
+		fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(f.fn()))
+		return
+	}
+
+	for _, k := range f.sortedKeys() {
+		s := f.series[k]
+		s.mu.Lock()
+		switch f.kind {
+		case KindHistogram:
+			var cumulative uint64
+			for i, le := range f.buckets {
+				cumulative += s.counts[i]
+				fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelString(f.labelNames, s.labelValues, "le", formatFloat(le)), cumulative)
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelString(f.labelNames, s.labelValues, "le", "+Inf"), s.count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelString(f.labelNames, s.labelValues), formatFloat(s.sum))
+			fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelString(f.labelNames, s.labelValues), s.count)
+		case KindSummary:
+			fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelString(f.labelNames, s.labelValues), formatFloat(s.sum))
+			fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelString(f.labelNames, s.labelValues), s.count)
+		default:
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labelString(f.labelNames, s.labelValues), formatFloat(s.value))
+		}
+		s.mu.Unlock()
+	}
+}
+
+// labelString renders {a="x",b="y"}; extra holds an additional name/value pair
+func labelString(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", name, escapeLabel(values[i]))
+	}
+	if len(extra) == 2 {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", extra[0], escapeLabel(extra[1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return fmt.Sprintf("%g", v)
+}
@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func(r *Registry)
+		want   string
+	}{
+		{
+			name: "counter with labels",
+			record: func(r *Registry) {
+				c := r.NewCounterVec("orders_total", "Orders seen.", "region", "status")
+				c.With("us", "ok").Add(2)
+				c.With("eu", "ok").Inc()
+				c.With("eu", "ok").Add(-5) // counters never go down
+			},
+			want: `# HELP orders_total Orders seen.
+# TYPE orders_total counter
+orders_total{region="eu",status="ok"} 1
+orders_total{region="us",status="ok"} 2
+`,
+		},
+		{
+			name: "escaping",
+			record: func(r *Registry) {
+				r.NewGaugeVec("temp", "Line one\nback\\slash \"quoted\".", "path").With("a\"b\\c\nd").Set(-1.5)
+			},
+			want: `# HELP temp Line one\nback\\slash "quoted".
+# TYPE temp gauge
+temp{path="a\"b\\c\nd"} -1.5
+`,
+		},
+		{
+			name: "gauge func and special values",
+			record: func(r *Registry) {
+				r.NewGaugeFunc("b_up", "Up.", func() float64 { return 1 })
+				r.NewGaugeVec("a_inf", "Inf.").With().Set(math.Inf(1))
+			},
+			want: `# HELP a_inf Inf.
+# TYPE a_inf gauge
+a_inf +Inf
+# HELP b_up Up.
+# TYPE b_up gauge
+b_up 1
+`,
+		},
+		{
+			name: "histogram buckets are cumulative",
+			record: func(r *Registry) {
+				h := r.NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.1}, "queue").With("q")
+				for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+					h.Observe(v)
+				}
+			},
+			want: `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{queue="q",le="0.1"} 2
+latency_seconds_bucket{queue="q",le="1"} 3
+latency_seconds_bucket{queue="q",le="+Inf"} 4
+latency_seconds_sum{queue="q"} 3.65
+latency_seconds_count{queue="q"} 4
+`,
+		},
+		{
+			name: "summary",
+			record: func(r *Registry) {
+				s := r.NewSummaryVec("order_value", "Order value.").With()
+				s.Observe(10)
+				s.Observe(32.5)
+			},
+			want: `# HELP order_value Order value.
+# TYPE order_value summary
+order_value_sum 42.5
+order_value_count 2
+`,
+		},
+		{
+			name: "families without series are omitted",
+			record: func(r *Registry) {
+				r.NewCounterVec("unused_total", "Never incremented.", "queue")
+			},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			tt.record(r)
+			var b strings.Builder
+			r.WritePrometheus(&b)
+			if got := b.String(); got != tt.want {
+				t.Errorf("WritePrometheus:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidMetricsAreDropped(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func(r *Registry)
+	}{
+		{"invalid name", func(r *Registry) { r.NewCounterVec("orders-total", "").With().Inc() }},
+		{"name starting with a digit", func(r *Registry) { r.NewGaugeVec("1up", "").With().Set(1) }},
+		{"invalid label name", func(r *Registry) { r.NewCounterVec("ok_total", "", "bad-label").With("x").Inc() }},
+		{"reserved label name", func(r *Registry) { r.NewCounterVec("ok_total", "", "__name").With("x").Inc() }},
+		{"le on a histogram", func(r *Registry) { r.NewHistogramVec("h", "", nil, "le").With("1").Observe(1) }},
+		{"too few label values", func(r *Registry) { r.NewCounterVec("ok_total", "", "queue").With().Inc() }},
+		{"too many label values", func(r *Registry) { r.NewHistogramVec("h", "", nil).With("x").Observe(1) }},
+		{"invalid gauge func", func(r *Registry) { r.NewGaugeFunc("bad name", "", func() float64 { return 1 }) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			tt.record(r)
+			tt.record(r) // logged once, dropped every time
+			var b strings.Builder
+			r.WritePrometheus(&b)
+			if b.Len() != 0 {
+				t.Errorf("dropped samples were exported:\n%s", b.String())
+			}
+		})
+	}
+}
+
+func TestConflictingRegistrationKeepsTheFirst(t *testing.T) {
+	tests := []struct {
+		name     string
+		conflict func(r *Registry)
+	}{
+		{"other kind", func(r *Registry) { r.NewSummaryVec("orders", "").With("x").Observe(1) }},
+		{"other label names", func(r *Registry) { r.NewCounterVec("orders", "", "status").With("x").Inc() }},
+		{"other label count", func(r *Registry) { r.NewCounterVec("orders", "").With().Inc() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			r.NewCounterVec("orders", "Orders.", "region").With("eu").Inc()
+			tt.conflict(r)
+			samples := r.Gather()
+			if len(samples) != 1 || samples[0].Labels != `{region="eu"}` || samples[0].Value != 1 {
+				t.Errorf("samples = %+v, want only orders{region=\"eu\"} 1", samples)
+			}
+		})
+	}
+}
+
+func TestRecordHelpers(t *testing.T) {
+	// Default outlives a single run of the test, so compare deltas
+	snapshot := func() map[string]Sample {
+		got := make(map[string]Sample)
+		for _, s := range Default.Gather() {
+			if strings.HasPrefix(s.Name, "test_record_") {
+				got[s.Name] = s
+			}
+		}
+		return got
+	}
+	before := snapshot()
+
+	IncCounter("test_record_orders")
+	AddCounter("test_record_orders", 2)
+	ObserveValue("test_record_value", 10)
+	Since("test_record_time", time.Now().Add(-time.Second))
+	// a name reused with another kind, and an invalid one, must not panic
+	ObserveValue("test_record_orders", 1)
+	ObserveDuration("test record time", time.Second)
+
+	after := snapshot()
+	if s := after["test_record_orders"]; s.Kind != KindCounter || s.Value-before["test_record_orders"].Value != 3 {
+		t.Errorf("test_record_orders = %+v, want a counter up by 3", s)
+	}
+	if s, b := after["test_record_value"], before["test_record_value"]; s.Kind != KindSummary || s.Sum-b.Sum != 10 || s.Count-b.Count != 1 {
+		t.Errorf("test_record_value = %+v, want a summary up by one 10", s)
+	}
+	if s, b := after["test_record_time"], before["test_record_time"]; s.Kind != KindHistogram || s.Count-b.Count != 1 || s.Sum-b.Sum < 1 {
+		t.Errorf("test_record_time = %+v, want one more observation of at least 1s", s)
+	}
+	if len(after) != 3 {
+		t.Errorf("record helpers created %d families, want 3", len(after))
+	}
+}
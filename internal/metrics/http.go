@@ -0,0 +1,33 @@
+// internal/metrics/http.go
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// contentType is the Prometheus text exposition format, version 0.0.4
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler serves the Default registry for Prometheus to scrape
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// Handler serves the registry for Prometheus to scrape
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		// render first so a slow scraper does not hold the series locks
+		var buf bytes.Buffer
+		r.WritePrometheus(&buf)
+		w.Header().Set("Content-Type", contentType)
+		w.Write(buf.Bytes())
+	})
+}
+
+// ListenAndServe exposes the Default registry on addr at /metrics
+func ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,39 @@
+// internal/metrics/record.go
+package metrics
+
+import "time"
+
+// Business-level helpers for handlers. Each name becomes an unlabelled
+// family in the Default registry the first time it is used, so both the
+// Reporter and /metrics pick it up. A name that is not a valid metric
+// name, or is already used by another helper (say IncCounter and then
+// ObserveValue on "orders"), is logged once and its samples dropped.
+
+// IncCounter adds one to the counter name, e.g. "orders_processed"
+func IncCounter(name string) {
+	AddCounter(name, 1)
+}
+
+// AddCounter adds delta to the counter name
+func AddCounter(name string, delta float64) {
+	Default.NewCounterVec(name, name).With().Add(delta)
+}
+
+// ObserveDuration records how long something took, e.g.
+// "order_processing_time". Durations are kept in seconds.
+func ObserveDuration(name string, d time.Duration) {
+	Default.NewHistogramVec(name, name, nil).With().Observe(d.Seconds())
+}
+
+// Since records the time elapsed since start, for use with defer:
+//
+//	defer metrics.Since("order_processing_time", time.Now())
+func Since(name string, start time.Time) {
+	ObserveDuration(name, time.Since(start))
+}
+
+// ObserveValue records an amount whose total and average matter, e.g.
+// metrics.ObserveValue("order_value", order.Price)
+func ObserveValue(name string, value float64) {
+	Default.NewSummaryVec(name, name).With().Observe(value)
+}
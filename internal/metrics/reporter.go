@@ -0,0 +1,181 @@
+// internal/metrics/reporter.go
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const reportRule = "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━"
+
+// Reporter periodically logs a registry as one "metrics report" record:
+// counters, gauges, average durations and value totals and averages, in a
+// group per kind. Report renders the same as a boxed "📊 Metrics Report"
+// for terminals.
+type Reporter struct {
+	Service  string
+	Interval time.Duration
+	Registry *Registry
+	Logger   *slog.Logger // default: slog.Default()
+}
+
+// NewReporter reports the Default registry every 30 seconds
+func NewReporter(service string) *Reporter {
+	return &Reporter{
+		Service:  service,
+		Interval: 30 * time.Second,
+		Registry: Default,
+	}
+}
+
+// Run logs a report every Interval until ctx is done, then logs a final one
+func (r *Reporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			r.log()
+			return
+		case <-ticker.C:
+			r.log()
+		}
+	}
+}
+
+func (r *Reporter) log() {
+	l := r.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+	l.LogAttrs(context.Background(), slog.LevelInfo, "metrics report", r.attrs()...)
+}
+
+// attrs holds the samples of Report: counters and gauges by name, and a
+// group per duration or value
+func (r *Reporter) attrs() []slog.Attr {
+	var counters, gauges, durations, values []any
+	for _, s := range r.Registry.Gather() {
+		name := s.Name + s.Labels
+		switch s.Kind {
+		case KindCounter:
+			counters = append(counters, slog.Float64(name, s.Value))
+		case KindGauge:
+			gauges = append(gauges, slog.Float64(name, s.Value))
+		case KindHistogram:
+			if s.Count == 0 {
+				continue
+			}
+			avg := time.Duration(s.Sum / float64(s.Count) * float64(time.Second))
+			durations = append(durations, slog.Group(name,
+				slog.Duration("avg", roundDuration(avg)), slog.Uint64("count", s.Count)))
+		case KindSummary:
+			if s.Count == 0 {
+				continue
+			}
+			values = append(values, slog.Group(name,
+				slog.Float64("total", s.Sum), slog.Float64("avg", s.Sum/float64(s.Count)), slog.Uint64("count", s.Count)))
+		}
+	}
+	return []slog.Attr{
+		slog.String("service", r.Service),
+		slog.Group("counters", counters...),
+		slog.Group("gauges", gauges...),
+		slog.Group("durations", durations...),
+		slog.Group("values", values...),
+	}
+}
+
+// Report renders the current state of the registry
+func (r *Reporter) Report() string {
+	var counters, gauges, durations, values []string
+	for _, s := range r.Registry.Gather() {
+		name := s.Name + s.Labels
+		switch s.Kind {
+		case KindCounter:
+			counters = append(counters, fmt.Sprintf("%s: %s", name, formatAmount(s.Value, 0)))
+		case KindGauge:
+			gauges = append(gauges, fmt.Sprintf("%s: %s", name, formatAmount(s.Value, 2)))
+		case KindHistogram:
+			if s.Count == 0 {
+				continue
+			}
+			avg := time.Duration(s.Sum / float64(s.Count) * float64(time.Second))
+			durations = append(durations, fmt.Sprintf("%s: avg=%s, count=%d", name, roundDuration(avg), s.Count))
+		case KindSummary:
+			if s.Count == 0 {
+				continue
+			}
+			values = append(values, fmt.Sprintf("%s: total=%s, avg=%s, count=%d",
+				name, formatAmount(s.Sum, 2), formatAmount(s.Sum/float64(s.Count), 2), s.Count))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("\n" + reportRule + "\n")
+	fmt.Fprintf(&b, "📊 Metrics Report [%s]\n", r.Service)
+	b.WriteString(reportRule + "\n")
+	section(&b, "📈 Counters:", counters)
+	section(&b, "🌡️  Gauges:", gauges)
+	section(&b, "⏱️  Durations:", durations)
+	section(&b, "💰 Values:", values)
+	if len(counters)+len(gauges)+len(durations)+len(values) == 0 {
+		b.WriteString("   (no metrics recorded yet)\n")
+	}
+	b.WriteString(reportRule)
+	return b.String()
+}
+
+func section(b *strings.Builder, title string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	b.WriteString(title + "\n")
+	for _, line := range lines {
+		b.WriteString("   • " + line + "\n")
+	}
+}
+
+// roundDuration keeps three significant units, e.g. 203ms or 1.52s
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Microsecond)
+}
+
+// formatAmount prints v with thousands separators, e.g. 184,293.58
+func formatAmount(v float64, decimals int) string {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return formatFloat(v)
+	}
+	if decimals == 0 && v != math.Trunc(v) {
+		decimals = 2
+	}
+	s := strconv.FormatFloat(math.Abs(v), 'f', decimals, 64)
+	intPart, frac, _ := strings.Cut(s, ".")
+
+	var b strings.Builder
+	// no sign on amounts that round to zero, e.g. -0.001
+	if v < 0 && strings.ContainsAny(s, "123456789") {
+		b.WriteByte('-')
+	}
+	for i, c := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	if frac != "" {
+		b.WriteString("." + frac)
+	}
+	return b.String()
+}
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func(r *Registry)
+		want   string
+	}{
+		{
+			name:   "empty",
+			record: func(*Registry) {},
+			want: `
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
+📊 Metrics Report [orders]
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
+   (no metrics recorded yet)
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━`,
+		},
+		{
+			name: "every section",
+			record: func(r *Registry) {
+				c := r.NewCounterVec("orders_total", "Orders.", "region")
+				c.With("us").Add(1_234_567)
+				c.With("eu").Add(12.5)
+				r.NewGaugeVec("balance", "Balance.").With().Set(-1234.567)
+				r.NewGaugeVec("in_flight", "In flight.").With().Set(3)
+				h := r.NewHistogramVec("handle_seconds", "Handling.", nil).With()
+				h.Observe(0.2)
+				h.Observe(0.2064)
+				r.NewHistogramVec("idle_seconds", "Never observed.", nil).With()
+				v := r.NewSummaryVec("order_value", "Value.").With()
+				v.Observe(184_000.50)
+				v.Observe(293.08)
+				v.Observe(0.001)
+			},
+			want: `
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
+📊 Metrics Report [orders]
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
+📈 Counters:
+   • orders_total{region="eu"}: 12.50
+   • orders_total{region="us"}: 1,234,567
+🌡️  Gauges:
+   • balance: -1,234.57
+   • in_flight: 3.00
+⏱️  Durations:
+   • handle_seconds: avg=203ms, count=2
+💰 Values:
+   • order_value: total=184,293.58, avg=61,431.19, count=3
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			tt.record(r)
+			rep := &Reporter{Service: "orders", Interval: time.Minute, Registry: r}
+			if got := rep.Report(); got != tt.want {
+				t.Errorf("Report() =%s\nwant%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		v        float64
+		decimals int
+		want     string
+	}{
+		{0, 0, "0"},
+		{999, 0, "999"},
+		{1000, 0, "1,000"},
+		{1_234_567, 0, "1,234,567"},
+		{-1_234_567, 0, "-1,234,567"},
+		{-999, 0, "-999"},
+		{12.5, 0, "12.50"}, // fractional counters get two decimals
+		{184_293.576, 2, "184,293.58"},
+		{2.004, 2, "2.00"},
+		{999.995, 2, "1,000.00"},
+		{-999.999, 2, "-1,000.00"},
+		{-0.001, 2, "0.00"},
+		{-0.4, 0, "-0.40"},
+		{0.5, 0, "0.50"},
+		{1e15, 2, "1,000,000,000,000,000.00"},
+		{math.Inf(1), 2, "+Inf"},
+		{math.Inf(-1), 0, "-Inf"},
+		{math.NaN(), 2, "NaN"},
+	}
+	for _, tt := range tests {
+		if got := formatAmount(tt.v, tt.decimals); got != tt.want {
+			t.Errorf("formatAmount(%v, %d) = %q, want %q", tt.v, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestRoundDuration(t *testing.T) {
+	tests := []struct {
+		d, want time.Duration
+	}{
+		{1523 * time.Millisecond, 1520 * time.Millisecond},
+		{203400 * time.Microsecond, 203 * time.Millisecond},
+		{1500 * time.Nanosecond, 2 * time.Microsecond},
+		{0, 0},
+	}
+	for _, tt := range tests {
+		if got := roundDuration(tt.d); got != tt.want {
+			t.Errorf("roundDuration(%v) = %v, want %v", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestReporterRunLogs(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("orders_total", "Orders.", "region").With("eu").Add(3)
+	r.NewGaugeVec("in_flight", "In flight.").With().Set(2)
+	r.NewHistogramVec("handle_seconds", "Handling.", nil).With().Observe(0.25)
+	r.NewSummaryVec("order_value", "Value.").With().Observe(99.5)
+
+	var buf bytes.Buffer
+	rep := &Reporter{Service: "orders", Interval: time.Hour, Registry: r,
+		Logger: slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+			ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		}))}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rep.Run(ctx) // logs the final report and returns
+
+	want := `{"level":"INFO","msg":"metrics report","service":"orders",` +
+		`"counters":{"orders_total{region=\"eu\"}":3},"gauges":{"in_flight":2},` +
+		`"durations":{"handle_seconds":{"avg":250000000,"count":1}},` +
+		`"values":{"order_value":{"total":99.5,"avg":99.5,"count":1}}}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("logged\n%s\nwant\n%s", got, want)
+	}
+}
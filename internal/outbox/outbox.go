@@ -0,0 +1,50 @@
+// internal/outbox/outbox.go
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Event is a message waiting in the outbox to be published
+type Event struct {
+	ID          int64
+	Exchange    string
+	RoutingKey  string
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	Attempts    int
+	LastError   string
+}
+
+// Outbox stores events in the same database as the business data so that
+// both are committed (or rolled back) together. A Relay later publishes
+// the pending events to RabbitMQ.
+type Outbox interface {
+	// Add stores an event as part of tx
+	Add(ctx context.Context, tx *sql.Tx, ev Event) error
+	// Pending returns up to limit unsent events that are due for a
+	// (re)try, oldest first
+	Pending(ctx context.Context, limit int) ([]Event, error)
+	// MarkSent records that the broker confirmed the event
+	MarkSent(ctx context.Context, id int64) error
+	// MarkFailed records a failed attempt and when to try again
+	MarkFailed(ctx context.Context, id int64, retryAt time.Time, cause error) error
+}
+
+// AddJSON encodes val as JSON and adds it to the outbox within tx
+func AddJSON[T any](ctx context.Context, o Outbox, tx *sql.Tx, exchange, key string, val T) error {
+	body, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return o.Add(ctx, tx, Event{
+		Exchange:    exchange,
+		RoutingKey:  key,
+		ContentType: "application/json",
+		Body:        body,
+	})
+}
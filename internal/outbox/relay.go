@@ -0,0 +1,233 @@
+// internal/outbox/relay.go
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Relay publishes pending outbox events with publisher confirms and marks
+// them sent once the broker has confirmed them. Events are published as
+// mandatory, so an event no queue is bound for counts as failed instead of
+// being confirmed and lost. Failed events are retried with exponential
+// backoff. Run a single relay per outbox table.
+type Relay struct {
+	Outbox       Outbox
+	BatchSize    int           // events fetched per poll
+	PollInterval time.Duration // wait between polls when the outbox is empty
+	MinBackoff   time.Duration // delay before the first retry
+	MaxBackoff   time.Duration // upper bound for the retry delay
+	// Logger receives failed events and relay errors (default:
+	// slog.Default())
+	Logger *slog.Logger
+
+	open     func() (relayChannel, error)
+	ch       relayChannel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+}
+
+// relayChannel is the channel a Relay publishes on, an *amqp.Channel
+// outside of tests
+type relayChannel interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(returns chan amqp.Return) chan amqp.Return
+	GetNextPublishSeqNo() uint64
+	IsClosed() bool
+	Close() error
+}
+
+// NewRelay creates a relay publishing the events of o over conn
+func NewRelay(conn *amqp.Connection, o Outbox) *Relay {
+	return newRelay(func() (relayChannel, error) {
+		return conn.Channel()
+	}, o)
+}
+
+func newRelay(open func() (relayChannel, error), o Outbox) *Relay {
+	return &Relay{
+		Outbox:       o,
+		BatchSize:    100,
+		PollInterval: time.Second,
+		MinBackoff:   time.Second,
+		MaxBackoff:   5 * time.Minute,
+		open:         open,
+	}
+}
+
+// Run relays events until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) error {
+	defer func() {
+		if r.ch != nil {
+			r.ch.Close()
+		}
+	}()
+
+	for {
+		sent, err := r.RelayOnce(ctx)
+		if err != nil {
+			r.logger().Error("outbox relay failed", "error", err)
+		}
+		// keep draining while there is a full batch waiting
+		if sent == r.BatchSize && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.PollInterval):
+		}
+	}
+}
+
+// RelayOnce publishes one batch of pending events and returns how many
+// of them were confirmed by the broker
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	events, err := r.Outbox.Pending(ctx, r.BatchSize)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+	if err := r.ensureChannel(); err != nil {
+		return 0, err
+	}
+
+	// confirms arrive in publish order, one per delivery tag from first on
+	first := r.ch.GetNextPublishSeqNo()
+	for i, ev := range events {
+		err := r.ch.PublishWithContext(ctx, ev.Exchange, ev.RoutingKey, true, false,
+			amqp.Publishing{
+				ContentType:  ev.ContentType,
+				DeliveryMode: amqp.Persistent,
+				MessageId:    messageID(ev.ID),
+				Timestamp:    ev.CreatedAt,
+				Body:         ev.Body,
+			},
+		)
+		if err != nil {
+			// the rest of the batch is retried on the next poll
+			r.fail(ctx, ev, fmt.Errorf("publish: %w", err))
+			events = events[:i]
+			break
+		}
+	}
+
+	sent := 0
+	returns := r.returns
+	returned := make(map[string]string) // reply text by message id
+	for confirmed := 0; confirmed < len(events); {
+		select {
+		case <-ctx.Done():
+			// unconfirmed events stay pending and are published again
+			return sent, ctx.Err()
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			returned[ret.MessageId] = ret.ReplyText
+		case c, ok := <-r.confirms:
+			if !ok {
+				// the channel closed: fail whatever is left unconfirmed
+				for _, ev := range events[confirmed:] {
+					r.fail(ctx, ev, fmt.Errorf("confirm: %w", amqp.ErrClosed))
+				}
+				return sent, nil
+			}
+			if c.DeliveryTag < first || c.DeliveryTag-first >= uint64(len(events)) {
+				continue // left over from a batch that gave up waiting
+			}
+			ev := events[c.DeliveryTag-first]
+			confirmed++
+			// the broker sends basic.return before it acks the message,
+			// so a return for ev is already waiting if there is one
+			drainReturns(returns, returned)
+			if text, ok := returned[messageID(ev.ID)]; ok {
+				r.fail(ctx, ev, fmt.Errorf("unroutable: %s", text))
+				continue
+			}
+			if !c.Ack {
+				r.fail(ctx, ev, errors.New("broker nacked the message"))
+				continue
+			}
+			if err := r.Outbox.MarkSent(ctx, ev.ID); err != nil {
+				// it will be published again: consumers must be idempotent
+				return sent, err
+			}
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+func drainReturns(returns <-chan amqp.Return, returned map[string]string) {
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				return
+			}
+			returned[ret.MessageId] = ret.ReplyText
+		default:
+			return
+		}
+	}
+}
+
+func messageID(id int64) string {
+	return "outbox-" + strconv.FormatInt(id, 10)
+}
+
+func (r *Relay) fail(ctx context.Context, ev Event, cause error) {
+	retryAt := time.Now().Add(r.backoff(ev.Attempts))
+	r.logger().Warn("outbox event failed",
+		"event_id", ev.ID, "attempt", ev.Attempts+1, "retry_at", retryAt, "error", cause)
+	if err := r.Outbox.MarkFailed(ctx, ev.ID, retryAt, cause); err != nil {
+		r.logger().Error("outbox relay failed to mark event failed", "event_id", ev.ID, "error", err)
+	}
+}
+
+func (r *Relay) logger() *slog.Logger {
+	if r.Logger != nil {
+		return r.Logger
+	}
+	return slog.Default()
+}
+
+// backoff doubles the delay for every previous attempt, up to MaxBackoff
+func (r *Relay) backoff(attempts int) time.Duration {
+	d := r.MinBackoff
+	for i := 0; i < attempts && d < r.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, r.MaxBackoff)
+}
+
+// ensureChannel (re)opens the confirm-mode publishing channel
+func (r *Relay) ensureChannel() error {
+	if r.ch != nil && !r.ch.IsClosed() {
+		return nil
+	}
+	ch, err := r.open()
+	if err != nil {
+		return fmt.Errorf("failed to open channel: %w", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return fmt.Errorf("failed to enable confirms: %w", err)
+	}
+	// room for a whole batch, so the connection's reader never blocks on
+	// us while we are still publishing
+	size := max(r.BatchSize, 1)
+	r.ch = ch
+	r.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, size))
+	r.returns = ch.NotifyReturn(make(chan amqp.Return, size))
+	return nil
+}
@@ -0,0 +1,385 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// memoryOutbox is an Outbox that records what the relay marked
+type memoryOutbox struct {
+	mu     sync.Mutex
+	events []Event
+	sent   []int64
+	failed map[int64]failure
+	// markSentErr fails MarkSent for this id
+	markSentErr int64
+}
+
+type failure struct {
+	retryAt time.Time
+	cause   string
+}
+
+func newMemoryOutbox(events ...Event) *memoryOutbox {
+	return &memoryOutbox{events: events, failed: make(map[int64]failure)}
+}
+
+func (o *memoryOutbox) Add(context.Context, *sql.Tx, Event) error {
+	return errors.New("not supported")
+}
+
+func (o *memoryOutbox) Pending(_ context.Context, limit int) ([]Event, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var pending []Event
+	for _, ev := range o.events {
+		if _, failed := o.failed[ev.ID]; failed || slices.Contains(o.sent, ev.ID) {
+			continue
+		}
+		if len(pending) == limit {
+			break
+		}
+		pending = append(pending, ev)
+	}
+	return pending, nil
+}
+
+func (o *memoryOutbox) MarkSent(_ context.Context, id int64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if id == o.markSentErr {
+		return errors.New("database is locked")
+	}
+	o.sent = append(o.sent, id)
+	return nil
+}
+
+func (o *memoryOutbox) MarkFailed(_ context.Context, id int64, retryAt time.Time, cause error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.failed[id] = failure{retryAt: retryAt, cause: cause.Error()}
+	return nil
+}
+
+func (o *memoryOutbox) failedIDs() []int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var ids []int64
+	for id := range o.failed {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// brokerAnswer is how fakeRelayChannel answers a publish
+type brokerAnswer int
+
+const (
+	answerAck brokerAnswer = iota
+	answerNack
+	answerReturn      // basic.return followed by an ack, like an unroutable mandatory message
+	answerPublishFail // PublishWithContext fails
+	answerClose       // the channel closes before confirming
+	answerNone        // never confirmed
+)
+
+// fakeRelayChannel confirms publishes like a confirm-mode channel,
+// answering each message as answer says
+type fakeRelayChannel struct {
+	answer func(msg amqp.Publishing) brokerAnswer
+
+	mu        sync.Mutex
+	seq       uint64
+	confirms  chan amqp.Confirmation
+	returns   chan amqp.Return
+	closed    bool
+	published []amqp.Publishing
+	mandatory []bool
+}
+
+func (c *fakeRelayChannel) Confirm(bool) error { return nil }
+
+func (c *fakeRelayChannel) NotifyPublish(confirms chan amqp.Confirmation) chan amqp.Confirmation {
+	c.confirms = confirms
+	return confirms
+}
+
+func (c *fakeRelayChannel) NotifyReturn(returns chan amqp.Return) chan amqp.Return {
+	c.returns = returns
+	return returns
+}
+
+func (c *fakeRelayChannel) GetNextPublishSeqNo() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seq + 1
+}
+
+func (c *fakeRelayChannel) PublishWithContext(_ context.Context, _, key string, mandatory, _ bool, msg amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	answer := c.answer(msg)
+	if answer == answerPublishFail {
+		return errors.New("connection blocked")
+	}
+	c.seq++
+	c.published = append(c.published, msg)
+	c.mandatory = append(c.mandatory, mandatory)
+	switch answer {
+	case answerAck:
+		c.confirms <- amqp.Confirmation{DeliveryTag: c.seq, Ack: true}
+	case answerNack:
+		c.confirms <- amqp.Confirmation{DeliveryTag: c.seq}
+	case answerReturn:
+		c.returns <- amqp.Return{MessageId: msg.MessageId, RoutingKey: key, ReplyCode: 312, ReplyText: "NO_ROUTE"}
+		c.confirms <- amqp.Confirmation{DeliveryTag: c.seq, Ack: true}
+	case answerClose:
+		c.close()
+	}
+	return nil
+}
+
+func (c *fakeRelayChannel) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *fakeRelayChannel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.close()
+	return nil
+}
+
+func (c *fakeRelayChannel) close() {
+	if !c.closed {
+		c.closed = true
+		close(c.confirms)
+		close(c.returns)
+	}
+}
+
+// answerFor answers the events by id, acking the others
+func answerFor(answers map[int64]brokerAnswer) func(amqp.Publishing) brokerAnswer {
+	return func(msg amqp.Publishing) brokerAnswer {
+		for id, a := range answers {
+			if msg.MessageId == messageID(id) {
+				return a
+			}
+		}
+		return answerAck
+	}
+}
+
+func events(n int) []Event {
+	var evs []Event
+	for i := 1; i <= n; i++ {
+		evs = append(evs, Event{ID: int64(i), Exchange: "peril_topic", RoutingKey: "order.us.1", Body: []byte("{}")})
+	}
+	return evs
+}
+
+func TestRelayOnce(t *testing.T) {
+	tests := []struct {
+		name       string
+		answers    map[int64]brokerAnswer
+		wantSent   []int64
+		wantFailed []int64
+		wantCause  string // of the failures
+	}{
+		{
+			name:     "all confirmed",
+			wantSent: []int64{1, 2, 3, 4, 5},
+		},
+		{
+			name:       "nacked",
+			answers:    map[int64]brokerAnswer{2: answerNack},
+			wantSent:   []int64{1, 3, 4, 5},
+			wantFailed: []int64{2},
+			wantCause:  "nacked",
+		},
+		{
+			name:       "returned as unroutable",
+			answers:    map[int64]brokerAnswer{4: answerReturn},
+			wantSent:   []int64{1, 2, 3, 5},
+			wantFailed: []int64{4},
+			wantCause:  "unroutable: NO_ROUTE",
+		},
+		{
+			// the rest of the batch stays pending for the next poll
+			name:       "publish error",
+			answers:    map[int64]brokerAnswer{3: answerPublishFail},
+			wantSent:   []int64{1, 2},
+			wantFailed: []int64{3},
+			wantCause:  "publish: connection blocked",
+		},
+		{
+			// 3 is never confirmed, 4 cannot be published and 5 stays pending
+			name:       "channel closed before confirming",
+			answers:    map[int64]brokerAnswer{3: answerClose},
+			wantSent:   []int64{1, 2},
+			wantFailed: []int64{3, 4},
+			wantCause:  amqp.ErrClosed.Reason,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			box := newMemoryOutbox(events(5)...)
+			ch := &fakeRelayChannel{answer: answerFor(tt.answers)}
+			r := newRelay(func() (relayChannel, error) { return ch, nil }, box)
+
+			sent, err := r.RelayOnce(context.Background())
+			if err != nil {
+				t.Fatalf("RelayOnce: %v", err)
+			}
+			if sent != len(tt.wantSent) || !slices.Equal(box.sent, tt.wantSent) {
+				t.Errorf("sent %d, marked sent %v; want %v", sent, box.sent, tt.wantSent)
+			}
+			if got := box.failedIDs(); !slices.Equal(got, tt.wantFailed) {
+				t.Errorf("marked failed %v, want %v", got, tt.wantFailed)
+			}
+			for id, f := range box.failed {
+				if !strings.Contains(f.cause, tt.wantCause) {
+					t.Errorf("event %d failed with %q, want %q", id, f.cause, tt.wantCause)
+				}
+			}
+			for i, m := range ch.mandatory {
+				if !m {
+					t.Errorf("message %d was not published as mandatory", i)
+				}
+			}
+		})
+	}
+}
+
+func TestRelayOnceMarkSentError(t *testing.T) {
+	box := newMemoryOutbox(events(3)...)
+	box.markSentErr = 2
+	ch := &fakeRelayChannel{answer: answerFor(nil)}
+	r := newRelay(func() (relayChannel, error) { return ch, nil }, box)
+
+	sent, err := r.RelayOnce(context.Background())
+	if err == nil || sent != 1 {
+		t.Fatalf("RelayOnce = %d, %v; want 1 and the MarkSent error", sent, err)
+	}
+	// 2 and 3 stay pending and are published again
+	if pending, _ := box.Pending(context.Background(), 10); len(pending) != 2 {
+		t.Errorf("%d events pending, want 2", len(pending))
+	}
+}
+
+func TestRelayOnceGivesUpWithTheContext(t *testing.T) {
+	box := newMemoryOutbox(events(2)...)
+	ch := &fakeRelayChannel{answer: answerFor(map[int64]brokerAnswer{2: answerNone})}
+	r := newRelay(func() (relayChannel, error) { return ch, nil }, box)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	sent, err := r.RelayOnce(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || sent != 1 {
+		t.Fatalf("RelayOnce = %d, %v; want 1 and deadline exceeded", sent, err)
+	}
+	if failed := box.failedIDs(); len(failed) != 0 {
+		t.Errorf("marked failed %v, want the unconfirmed event left pending", failed)
+	}
+}
+
+func TestRelayReopensClosedChannel(t *testing.T) {
+	box := newMemoryOutbox(events(3)...)
+	var opened []*fakeRelayChannel
+	r := newRelay(func() (relayChannel, error) {
+		ch := &fakeRelayChannel{answer: answerFor(map[int64]brokerAnswer{2: answerClose})}
+		if len(opened) > 0 {
+			ch.answer = answerFor(nil)
+		}
+		opened = append(opened, ch)
+		return ch, nil
+	}, box)
+
+	if _, err := r.RelayOnce(context.Background()); err != nil {
+		t.Fatalf("first RelayOnce: %v", err)
+	}
+	// retry the failed events right away
+	box.failed = make(map[int64]failure)
+	sent, err := r.RelayOnce(context.Background())
+	if err != nil || sent != 2 {
+		t.Fatalf("second RelayOnce = %d, %v; want 2", sent, err)
+	}
+	if len(opened) != 2 {
+		t.Errorf("opened %d channels, want 2", len(opened))
+	}
+	if !slices.Equal(box.sent, []int64{1, 2, 3}) {
+		t.Errorf("marked sent %v, want [1 2 3]", box.sent)
+	}
+}
+
+func TestRelayBackoff(t *testing.T) {
+	r := newRelay(nil, nil)
+	r.MinBackoff, r.MaxBackoff = time.Second, time.Minute
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{3, 8 * time.Second},
+		{5, 32 * time.Second},
+		{6, time.Minute},
+		{100, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := r.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+
+	// a failed event is retried after the backoff of its attempts so far
+	box := newMemoryOutbox(Event{ID: 7, Attempts: 2})
+	ch := &fakeRelayChannel{answer: answerFor(map[int64]brokerAnswer{7: answerNack})}
+	r = newRelay(func() (relayChannel, error) { return ch, nil }, box)
+	start := time.Now()
+	r.RelayOnce(context.Background())
+	retryAt := box.failed[7].retryAt
+	if d := retryAt.Sub(start); d < 4*time.Second || d > 5*time.Second {
+		t.Errorf("retry in %v, want 4s after the third attempt", d)
+	}
+}
+
+func TestRelayLogsFailedEvents(t *testing.T) {
+	box := newMemoryOutbox(Event{ID: 7, Attempts: 2})
+	ch := &fakeRelayChannel{answer: answerFor(map[int64]brokerAnswer{7: answerNack})}
+	r := newRelay(func() (relayChannel, error) { return ch, nil }, box)
+	var buf bytes.Buffer
+	r.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+
+	r.RelayOnce(context.Background())
+	var rec struct {
+		Level   string `json:"level"`
+		Msg     string `json:"msg"`
+		EventID int64  `json:"event_id"`
+		Attempt int    `json:"attempt"`
+		Error   string `json:"error"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("log %q: %v", buf.String(), err)
+	}
+	if rec.Level != "WARN" || rec.Msg != "outbox event failed" || rec.EventID != 7 || rec.Attempt != 3 || !strings.Contains(rec.Error, "nacked") {
+		t.Errorf("logged %s", buf.String())
+	}
+}
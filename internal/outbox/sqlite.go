@@ -0,0 +1,118 @@
+// internal/outbox/sqlite.go
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS outbox (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	exchange        TEXT    NOT NULL,
+	routing_key     TEXT    NOT NULL,
+	content_type    TEXT    NOT NULL,
+	body            BLOB    NOT NULL,
+	created_at      INTEGER NOT NULL,
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at INTEGER NOT NULL,
+	last_error      TEXT    NOT NULL DEFAULT '',
+	sent_at         INTEGER
+);
+CREATE INDEX IF NOT EXISTS outbox_pending ON outbox (sent_at, next_attempt_at);
+`
+
+// SQLiteOutbox is an Outbox stored in an "outbox" table of a SQLite
+// database. The caller opens the *sql.DB with the driver of their choice
+// (e.g. modernc.org/sqlite) and uses the same DB for the business data.
+type SQLiteOutbox struct {
+	db *sql.DB
+}
+
+// NewSQLite creates the outbox table if needed
+func NewSQLite(ctx context.Context, db *sql.DB) (*SQLiteOutbox, error) {
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		return nil, fmt.Errorf("failed to create outbox table: %w", err)
+	}
+	return &SQLiteOutbox{db: db}, nil
+}
+
+func (o *SQLiteOutbox) Add(ctx context.Context, tx *sql.Tx, ev Event) error {
+	now := time.Now().UTC().UnixNano()
+	body := ev.Body
+	if body == nil {
+		body = []byte{} // a nil slice would be stored as NULL
+	}
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO outbox (exchange, routing_key, content_type, body, created_at, next_attempt_at)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		ev.Exchange, ev.RoutingKey, ev.ContentType, body, now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to add outbox event: %w", err)
+	}
+	return nil
+}
+
+func (o *SQLiteOutbox) Pending(ctx context.Context, limit int) ([]Event, error) {
+	rows, err := o.db.QueryContext(ctx,
+		`SELECT id, exchange, routing_key, content_type, body, created_at, attempts, last_error
+		 FROM outbox
+		 WHERE sent_at IS NULL AND next_attempt_at <= ?
+		 ORDER BY id
+		 LIMIT ?`,
+		time.Now().UTC().UnixNano(), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query outbox: %w", err)
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var ev Event
+		var created int64
+		if err := rows.Scan(&ev.ID, &ev.Exchange, &ev.RoutingKey, &ev.ContentType, &ev.Body, &created, &ev.Attempts, &ev.LastError); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox row: %w", err)
+		}
+		ev.CreatedAt = time.Unix(0, created).UTC()
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+func (o *SQLiteOutbox) MarkSent(ctx context.Context, id int64) error {
+	_, err := o.db.ExecContext(ctx,
+		`UPDATE outbox SET sent_at = ?, attempts = attempts + 1, last_error = '' WHERE id = ?`,
+		time.Now().UTC().UnixNano(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox event %d sent: %w", id, err)
+	}
+	return nil
+}
+
+func (o *SQLiteOutbox) MarkFailed(ctx context.Context, id int64, retryAt time.Time, cause error) error {
+	_, err := o.db.ExecContext(ctx,
+		`UPDATE outbox SET attempts = attempts + 1, next_attempt_at = ?, last_error = ? WHERE id = ?`,
+		retryAt.UTC().UnixNano(), cause.Error(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox event %d failed: %w", id, err)
+	}
+	return nil
+}
+
+// Purge deletes events that were sent before the given time
+func (o *SQLiteOutbox) Purge(ctx context.Context, sentBefore time.Time) (int64, error) {
+	res, err := o.db.ExecContext(ctx,
+		`DELETE FROM outbox WHERE sent_at IS NOT NULL AND sent_at < ?`,
+		sentBefore.UTC().UnixNano(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge outbox: %w", err)
+	}
+	return res.RowsAffected()
+}
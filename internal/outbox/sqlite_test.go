@@ -0,0 +1,126 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "orders.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLiteOutbox(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	box, err := NewSQLite(ctx, db)
+	if err != nil {
+		t.Fatalf("NewSQLite: %v", err)
+	}
+	// creating the table again is a no-op
+	if _, err := NewSQLite(ctx, db); err != nil {
+		t.Fatalf("NewSQLite on an existing table: %v", err)
+	}
+
+	add := func(key string, commit bool) {
+		t.Helper()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		if err := AddJSON(ctx, box, tx, "peril_topic", key, map[string]string{"id": key}); err != nil {
+			t.Fatalf("AddJSON: %v", err)
+		}
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			t.Fatalf("commit or rollback: %v", err)
+		}
+	}
+	add("order.us.1", true)
+	add("order.us.rolled-back", false)
+	add("order.eu.2", true)
+	add("order.eu.3", true)
+
+	pending, err := box.Pending(ctx, 2)
+	if err != nil {
+		t.Fatalf("Pending: %v", err)
+	}
+	if len(pending) != 2 || pending[0].RoutingKey != "order.us.1" || pending[1].RoutingKey != "order.eu.2" {
+		t.Fatalf("Pending(2) = %+v, want the two oldest committed events", pending)
+	}
+	first := pending[0]
+	if first.Exchange != "peril_topic" || first.ContentType != "application/json" || string(first.Body) != `{"id":"order.us.1"}` {
+		t.Errorf("event = %+v", first)
+	}
+	if age := time.Since(first.CreatedAt); age < 0 || age > time.Minute {
+		t.Errorf("CreatedAt = %v", first.CreatedAt)
+	}
+
+	// sent events are no longer pending
+	if err := box.MarkSent(ctx, first.ID); err != nil {
+		t.Fatalf("MarkSent: %v", err)
+	}
+	// failed events wait until their retry is due
+	second := pending[1]
+	if err := box.MarkFailed(ctx, second.ID, time.Now().Add(time.Hour), errors.New("broker nacked the message")); err != nil {
+		t.Fatalf("MarkFailed: %v", err)
+	}
+	pending, _ = box.Pending(ctx, 10)
+	if len(pending) != 1 || pending[0].RoutingKey != "order.eu.3" {
+		t.Fatalf("Pending = %+v, want only order.eu.3", pending)
+	}
+
+	if err := box.MarkFailed(ctx, second.ID, time.Now().Add(-time.Second), errors.New("unroutable: NO_ROUTE")); err != nil {
+		t.Fatalf("MarkFailed: %v", err)
+	}
+	pending, _ = box.Pending(ctx, 10)
+	if len(pending) != 2 || pending[0].ID != second.ID {
+		t.Fatalf("Pending = %+v, want the due retry first", pending)
+	}
+	if retry := pending[0]; retry.Attempts != 2 || retry.LastError != "unroutable: NO_ROUTE" {
+		t.Errorf("retried event = %+v, want 2 attempts and the last error", retry)
+	}
+
+	// only events sent before the cutoff are purged
+	if n, err := box.Purge(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("Purge(an hour ago) = %d, %v; want 0", n, err)
+	}
+	if n, err := box.Purge(ctx, time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Errorf("Purge(now) = %d, %v; want 1", n, err)
+	}
+	if pending, _ = box.Pending(ctx, 10); len(pending) != 2 {
+		t.Errorf("Purge removed unsent events: %d pending", len(pending))
+	}
+}
+
+func TestSQLiteOutboxEmptyBody(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	box, err := NewSQLite(ctx, db)
+	if err != nil {
+		t.Fatalf("NewSQLite: %v", err)
+	}
+	tx, _ := db.BeginTx(ctx, nil)
+	if err := box.Add(ctx, tx, Event{Exchange: "peril_topic", RoutingKey: "order.us.1"}); err != nil {
+		t.Fatalf("Add without a body: %v", err)
+	}
+	tx.Commit()
+	if pending, _ := box.Pending(ctx, 10); len(pending) != 1 || len(pending[0].Body) != 0 {
+		t.Errorf("Pending = %+v, want one event with an empty body", pending)
+	}
+}
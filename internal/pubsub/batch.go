@@ -0,0 +1,191 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// SubscribeBatch works like Subscribe but hands the handler up to size
+// messages at once, or fewer once maxWait has passed since the first
+// message of the batch arrived.
+//
+// The handler returns one AckType per message, in order. Returning a
+// single AckType applies it to the whole batch; any other length is a
+// bug and requeues the batch. When every message gets the same outcome
+// the batch is settled with a single multiple=true ack/nack.
+//
+// WithRateLimit takes one token per message of a batch. WithIdempotency
+// and WithPartitions work per message and return an error here. Each
+// message gets its own consumer span, ended when the batch is settled;
+// the handler's ctx does not carry them, as a batch spans many traces.
+func SubscribeBatch[T any](
+	conn *amqp.Connection,
+	exchange,
+	queueName,
+	key string,
+	queueType SimpleQueueType,
+	size int,
+	maxWait time.Duration,
+	handler func(context.Context, []*T) []AckType,
+	unmarshaller func([]byte) (*T, error),
+	opts ...Option,
+) error {
+	if size < 1 {
+		return fmt.Errorf("batch size must be at least 1, got %d", size)
+	}
+	if maxWait <= 0 {
+		return fmt.Errorf("batch maxWait must be positive, got %v", maxWait)
+	}
+	o := newOptions(opts)
+	if o.idempotency != nil {
+		return errors.New("SubscribeBatch does not support WithIdempotency")
+	}
+	if o.partitions > 0 {
+		return errors.New("SubscribeBatch does not support WithPartitions")
+	}
+	ch, _, err := DeclareAndBind(conn, exchange, queueName, key, queueType, opts...)
+	if err != nil {
+		return fmt.Errorf("at declaring and binding: %w", err)
+	}
+	// the broker must be allowed to send a full batch before any ack
+	if err := ch.Qos(max(o.prefetch, size), 0, false); err != nil {
+		return fmt.Errorf("failed prefetch limit: %w", err)
+	}
+	msgs, err := ch.Consume(queueName, consumerTag(queueName), false, false, false, false, consumeArgs(queueType, o))
+	if err != nil {
+		return fmt.Errorf("failed to register consumer: %w", err)
+	}
+
+	lg := o.logger.With(slog.String("queue", queueName))
+	consumed := messagesConsumed.With(queueName)
+	latency := handlerDuration.With(queueName)
+	go func() {
+		deliveries := make([]amqp.Delivery, 0, size)
+		spans := make([]*tracing.Span, 0, size)
+		batch := make([]*T, 0, size)
+		var timeout <-chan time.Time
+
+		flush := func() {
+			timeout = nil
+			if len(deliveries) == 0 {
+				return
+			}
+			waitLimiters(o.limiters, len(batch))
+			start := time.Now()
+			acks := handler(context.Background(), batch)
+			latency.Observe(time.Since(start).Seconds())
+			acks = settleBatch(lg, queueName, deliveries, acks)
+			for i, span := range spans {
+				span.SetAttributes(tracing.String("messaging.rabbitmq.outcome", acks[i].String()))
+				if acks[i] == Discard {
+					span.SetError(errors.New("message discarded by handler"))
+				}
+				span.End()
+			}
+			// the handler may keep the slice, start a fresh one
+			deliveries, batch = make([]amqp.Delivery, 0, size), make([]*T, 0, size)
+			spans = spans[:0]
+		}
+
+		for {
+			select {
+			case d, ok := <-msgs:
+				if !ok {
+					flush()
+					return
+				}
+				consumed.Inc()
+				_, span := startConsumeSpan(context.Background(), d, queueName)
+				msg, err := unmarshaller(d.Body)
+				if err != nil {
+					lg.Warn("failed to decode message, discarding", append(deliveryAttrs(d), "error", err)...)
+					d.Nack(false, false) // discard
+					messagesSettled.With(queueName, "decode_error").Inc()
+					span.SetError(fmt.Errorf("failed to decode message: %w", err))
+					span.End()
+					continue
+				}
+				if len(deliveries) == 0 {
+					timeout = time.After(maxWait)
+				}
+				deliveries = append(deliveries, d)
+				spans = append(spans, span)
+				batch = append(batch, msg)
+				if len(deliveries) >= size {
+					flush()
+				}
+			case <-timeout:
+				flush()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// settleBatch acks or nacks every delivery of a batch according to acks
+// and returns the outcome of each delivery
+func settleBatch(l *slog.Logger, queueName string, deliveries []amqp.Delivery, acks []AckType) []AckType {
+	if len(acks) == 1 && len(deliveries) > 1 {
+		acks = fillAcks(acks[0], len(deliveries))
+	}
+	if len(acks) != len(deliveries) {
+		l.Error("batch handler returned the wrong number of results, requeueing the batch", "results", len(acks), "messages", len(deliveries))
+		acks = fillAcks(Requeue, len(deliveries))
+	}
+	for i, ack := range acks {
+		messagesSettled.With(queueName, ack.String()).Inc()
+		logSettled(l, deliveries[i], ack)
+	}
+
+	// deliveries arrive in tag order on a channel, and decode failures
+	// are settled as they come, so acking the last tag with multiple=true
+	// settles exactly this batch
+	if same(acks) {
+		last := deliveries[len(deliveries)-1]
+		switch acks[0] {
+		case Ack:
+			last.Ack(true)
+		case Requeue:
+			last.Nack(true, true)
+		case Discard:
+			last.Nack(true, false)
+		}
+		return acks
+	}
+
+	for i, d := range deliveries {
+		switch acks[i] {
+		case Ack:
+			d.Ack(false)
+		case Requeue:
+			d.Nack(false, true)
+		case Discard:
+			d.Nack(false, false)
+		}
+	}
+	return acks
+}
+
+func fillAcks(ack AckType, n int) []AckType {
+	acks := make([]AckType, n)
+	for i := range acks {
+		acks[i] = ack
+	}
+	return acks
+}
+
+func same(acks []AckType) bool {
+	for _, a := range acks[1:] {
+		if a != acks[0] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package pubsub
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// nopStore is an IdempotencyStore that never remembers anything
+type nopStore struct{}
+
+func (nopStore) Claim(string) (bool, error) { return true, nil }
+func (nopStore) Put(string, AckType) error  { return nil }
+func (nopStore) Release(string) error       { return nil }
+
+func TestSubscribeBatchRejectsInvalidArguments(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		maxWait time.Duration
+		opts    []Option
+	}{
+		{"negative size", -1, time.Second, nil},
+		{"zero size", 0, time.Second, nil},
+		{"zero maxWait", 10, 0, nil},
+		{"negative maxWait", 10, -time.Second, nil},
+		{"idempotency", 10, time.Second, []Option{WithIdempotency(nopStore{})}},
+		{"partitions", 10, time.Second, []Option{WithPartitions(4, nil)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the arguments are checked before conn is used
+			err := SubscribeBatch(nil, "x", "q", "k", Durable, tt.size, tt.maxWait,
+				func(context.Context, []*int) []AckType { return nil },
+				JSONUnmarshaller[int], tt.opts...)
+			if err == nil {
+				t.Fatal("SubscribeBatch accepted arguments it cannot honour")
+			}
+		})
+	}
+}
+
+// settlement is one Ack, Nack or Reject seen by recordingAcker
+type settlement struct {
+	tag      uint64
+	ack      bool
+	multiple bool
+	requeue  bool
+}
+
+type recordingAcker struct {
+	settled []settlement
+}
+
+func (a *recordingAcker) Ack(tag uint64, multiple bool) error {
+	a.settled = append(a.settled, settlement{tag: tag, ack: true, multiple: multiple})
+	return nil
+}
+
+func (a *recordingAcker) Nack(tag uint64, multiple, requeue bool) error {
+	a.settled = append(a.settled, settlement{tag: tag, multiple: multiple, requeue: requeue})
+	return nil
+}
+
+func (a *recordingAcker) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func TestSettleBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		acks    []AckType
+		want    []AckType
+		settled []settlement
+	}{
+		{
+			name:    "all acked",
+			acks:    []AckType{Ack, Ack, Ack},
+			want:    []AckType{Ack, Ack, Ack},
+			settled: []settlement{{tag: 3, ack: true, multiple: true}},
+		},
+		{
+			name:    "single result applies to the batch",
+			acks:    []AckType{Discard},
+			want:    []AckType{Discard, Discard, Discard},
+			settled: []settlement{{tag: 3, multiple: true}},
+		},
+		{
+			name:    "wrong number of results requeues",
+			acks:    []AckType{Ack, Ack},
+			want:    []AckType{Requeue, Requeue, Requeue},
+			settled: []settlement{{tag: 3, multiple: true, requeue: true}},
+		},
+		{
+			name: "mixed results settle one by one",
+			acks: []AckType{Ack, Requeue, Discard},
+			want: []AckType{Ack, Requeue, Discard},
+			settled: []settlement{
+				{tag: 1, ack: true},
+				{tag: 2, requeue: true},
+				{tag: 3},
+			},
+		},
+	}
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acker := &recordingAcker{}
+			var deliveries []amqp.Delivery
+			for tag := uint64(1); tag <= 3; tag++ {
+				deliveries = append(deliveries, amqp.Delivery{Acknowledger: acker, DeliveryTag: tag})
+			}
+			if got := settleBatch(l, "q", deliveries, tt.acks); !slices.Equal(got, tt.want) {
+				t.Errorf("outcomes = %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(acker.settled, tt.settled) {
+				t.Errorf("settled %+v, want %+v", acker.settled, tt.settled)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package pubsub
+
+import (
+	"log/slog"
+)
+
+// IdempotencyStore remembers the outcome of messages that were already
+// processed, so redeliveries can be skipped. See the inbox package for
+// implementations.
+//
+// A key is claimed before its message is handled, so the same message
+// delivered twice at once (e.g. to two partitions, or two consumers
+// sharing a store) is handled only once.
+type IdempotencyStore interface {
+	// Claim atomically records that key is being processed. It returns
+	// false, without claiming, when key was already processed or is
+	// being processed under an earlier claim.
+	Claim(key string) (claimed bool, err error)
+	// Put records the final outcome of a claimed key
+	Put(key string, outcome AckType) error
+	// Release drops the claim of a key that still has to be processed
+	Release(key string) error
+}
+
+// IdempotentMiddleware skips messages whose key was already processed, or
+// is being processed concurrently, and acks them instead of running the
+// handler again. Only final outcomes (Ack and Discard) are recorded; a
+// Requeue releases the key as the message still has to be processed.
+// Store errors are logged and the handler runs anyway, keeping the
+// at-least-once guarantee.
+func IdempotentMiddleware[T any](store IdempotencyStore, key func(*T) string, handler func(*T) AckType) func(*T) AckType {
+	return func(msg *T) AckType {
+		k := key(msg)
+		if k == "" {
+			return handler(msg)
+		}
+		if !claim(logger(), store, k) {
+			return Ack
+		}
+		result := handler(msg)
+		settle(logger(), store, k, result)
+		return result
+	}
+}
+
+// WithIdempotency deduplicates deliveries by their MessageId before they
+// are decoded, with the same semantics as IdempotentMiddleware. Messages
+// without a MessageId are always processed.
+func WithIdempotency(store IdempotencyStore) Option {
+	return func(o *options) {
+		o.idempotency = store
+	}
+}
+
+// claim reports whether the message with key should be processed
+func claim(l *slog.Logger, store IdempotencyStore, key string) bool {
+	claimed, err := store.Claim(key)
+	if err != nil {
+		l.Error("idempotency store claim failed", "key", key, "error", err)
+		return true
+	}
+	if !claimed {
+		l.Info("skipping duplicate message", "key", key)
+	}
+	return claimed
+}
+
+// settle records the outcome of a claimed key, or releases it on Requeue
+func settle(l *slog.Logger, store IdempotencyStore, key string, outcome AckType) {
+	if outcome == Requeue {
+		release(l, store, key)
+		return
+	}
+	if err := store.Put(key, outcome); err != nil {
+		l.Error("idempotency store failed to record outcome", "key", key, "error", err)
+	}
+}
+
+func release(l *slog.Logger, store IdempotencyStore, key string) {
+	if err := store.Release(key); err != nil {
+		l.Error("idempotency store failed to release key", "key", key, "error", err)
+	}
+}
+
+func (a AckType) String() string {
+	switch a {
+	case Ack:
+		return "ack"
+	case Requeue:
+		return "requeue"
+	case Discard:
+		return "discard"
+	}
+	return "unknown"
+}
@@ -0,0 +1,332 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// mapStore is an IdempotencyStore in a map, failing every call while err
+// is set
+type mapStore struct {
+	mu       sync.Mutex
+	err      error
+	claimed  map[string]bool
+	outcomes map[string]AckType
+}
+
+func newMapStore() *mapStore {
+	return &mapStore{claimed: make(map[string]bool), outcomes: make(map[string]AckType)}
+}
+
+func (s *mapStore) Claim(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+	if _, done := s.outcomes[key]; done || s.claimed[key] {
+		return false, nil
+	}
+	s.claimed[key] = true
+	return true, nil
+}
+
+func (s *mapStore) Put(key string, outcome AckType) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	delete(s.claimed, key)
+	s.outcomes[key] = outcome
+	return nil
+}
+
+func (s *mapStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	delete(s.claimed, key)
+	return nil
+}
+
+func (s *mapStore) outcome(key string) (AckType, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.outcomes[key]
+	return a, ok
+}
+
+type payment struct {
+	OrderID string
+	Result  AckType
+}
+
+func TestIdempotentMiddleware(t *testing.T) {
+	errStore := errors.New("database is locked")
+	tests := []struct {
+		name      string
+		storeErr  error
+		messages  []payment
+		wantCalls int
+		wantAcks  []AckType
+		wantKept  map[string]AckType // outcomes recorded in the store
+	}{
+		{
+			name:      "duplicate is acked without running the handler",
+			messages:  []payment{{"ORD-1", Ack}, {"ORD-1", Ack}},
+			wantCalls: 1,
+			wantAcks:  []AckType{Ack, Ack},
+			wantKept:  map[string]AckType{"ORD-1": Ack},
+		},
+		{
+			name:      "discard is final",
+			messages:  []payment{{"ORD-1", Discard}, {"ORD-1", Ack}},
+			wantCalls: 1,
+			wantAcks:  []AckType{Discard, Ack},
+			wantKept:  map[string]AckType{"ORD-1": Discard},
+		},
+		{
+			name:      "requeue is not recorded",
+			messages:  []payment{{"ORD-1", Requeue}, {"ORD-1", Ack}, {"ORD-1", Ack}},
+			wantCalls: 2,
+			wantAcks:  []AckType{Requeue, Ack, Ack},
+			wantKept:  map[string]AckType{"ORD-1": Ack},
+		},
+		{
+			name:      "messages without a key always run",
+			messages:  []payment{{"", Ack}, {"", Ack}},
+			wantCalls: 2,
+			wantAcks:  []AckType{Ack, Ack},
+			wantKept:  map[string]AckType{},
+		},
+		{
+			name:      "store errors run the handler",
+			storeErr:  errStore,
+			messages:  []payment{{"ORD-1", Ack}, {"ORD-1", Ack}},
+			wantCalls: 2,
+			wantAcks:  []AckType{Ack, Ack},
+			wantKept:  map[string]AckType{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMapStore()
+			store.err = tt.storeErr
+			calls := 0
+			handler := IdempotentMiddleware(store, func(p *payment) string { return p.OrderID }, func(p *payment) AckType {
+				calls++
+				return p.Result
+			})
+			for i, msg := range tt.messages {
+				if got := handler(&msg); got != tt.wantAcks[i] {
+					t.Errorf("message %d: %v, want %v", i, got, tt.wantAcks[i])
+				}
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("handler ran %d times, want %d", calls, tt.wantCalls)
+			}
+			store.err = nil
+			if len(store.outcomes) != len(tt.wantKept) {
+				t.Errorf("recorded %v, want %v", store.outcomes, tt.wantKept)
+			}
+			for key, want := range tt.wantKept {
+				if got, ok := store.outcome(key); !ok || got != want {
+					t.Errorf("outcome of %s = %v, %v; want %v", key, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestIdempotentMiddlewareConcurrentDuplicates(t *testing.T) {
+	store := newMapStore()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := IdempotentMiddleware(store, func(p *payment) string { return p.OrderID }, func(*payment) AckType {
+		calls.Add(1)
+		<-release
+		return Ack
+	})
+
+	// the same payment delivered to several partitions at once
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler(&payment{OrderID: "ORD-1"})
+		}()
+	}
+	waitFor(t, "the first delivery", func() bool { return calls.Load() == 1 })
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler ran %d times for one payment", n)
+	}
+}
+
+// settleRecorder records how deliveries were settled
+type settleRecorder struct {
+	mu       sync.Mutex
+	outcomes []string
+}
+
+func (a *settleRecorder) Ack(uint64, bool) error { return a.record("ack") }
+
+func (a *settleRecorder) Nack(_ uint64, _, requeue bool) error {
+	if requeue {
+		return a.record("requeue")
+	}
+	return a.record("discard")
+}
+
+func (a *settleRecorder) Reject(tag uint64, requeue bool) error { return a.Nack(tag, false, requeue) }
+
+func (a *settleRecorder) record(outcome string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.outcomes = append(a.outcomes, outcome)
+	return nil
+}
+
+func TestWithIdempotency(t *testing.T) {
+	type delivery struct {
+		id   string
+		body string
+	}
+	tests := []struct {
+		name        string
+		deliveries  []delivery
+		result      AckType
+		wantCalls   int
+		wantSettled []string
+		wantKept    []string
+	}{
+		{
+			name:        "redelivery is acked as a duplicate",
+			deliveries:  []delivery{{"m-1", `1`}, {"m-1", `1`}, {"m-2", `2`}},
+			result:      Ack,
+			wantCalls:   2,
+			wantSettled: []string{"ack", "ack", "ack"},
+			wantKept:    []string{"m-1", "m-2"},
+		},
+		{
+			name:        "requeued message is processed again",
+			deliveries:  []delivery{{"m-1", `1`}, {"m-1", `1`}},
+			result:      Requeue,
+			wantCalls:   2,
+			wantSettled: []string{"requeue", "requeue"},
+		},
+		{
+			name:        "discard is final",
+			deliveries:  []delivery{{"m-1", `1`}, {"m-1", `1`}},
+			result:      Discard,
+			wantCalls:   1,
+			wantSettled: []string{"discard", "ack"},
+			wantKept:    []string{"m-1"},
+		},
+		{
+			name:        "no message id",
+			deliveries:  []delivery{{"", `1`}, {"", `1`}},
+			result:      Ack,
+			wantCalls:   2,
+			wantSettled: []string{"ack", "ack"},
+		},
+		{
+			// the claim is dropped, so a fixed redelivery is processed
+			name:        "undecodable message is not recorded",
+			deliveries:  []delivery{{"m-1", `nope`}, {"m-1", `1`}},
+			result:      Ack,
+			wantCalls:   1,
+			wantSettled: []string{"discard", "ack"},
+			wantKept:    []string{"m-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMapStore()
+			calls := 0
+			handle := deliveryHandler("test-idempotency", newOptions([]Option{WithIdempotency(store)}),
+				func(context.Context, *int) AckType {
+					calls++
+					return tt.result
+				},
+				bodyDecoder(JSONUnmarshaller[int]))
+
+			acker := &settleRecorder{}
+			for _, d := range tt.deliveries {
+				handle(amqp.Delivery{Acknowledger: acker, MessageId: d.id, Body: []byte(d.body)})
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("handler ran %d times, want %d", calls, tt.wantCalls)
+			}
+			if len(acker.outcomes) != len(tt.wantSettled) {
+				t.Fatalf("settled %v, want %v", acker.outcomes, tt.wantSettled)
+			}
+			for i, want := range tt.wantSettled {
+				if acker.outcomes[i] != want {
+					t.Errorf("settled %v, want %v", acker.outcomes, tt.wantSettled)
+					break
+				}
+			}
+			if len(store.outcomes) != len(tt.wantKept) || len(store.claimed) != 0 {
+				t.Errorf("recorded %v with claims %v, want %v", store.outcomes, store.claimed, tt.wantKept)
+			}
+			for _, key := range tt.wantKept {
+				if _, ok := store.outcome(key); !ok {
+					t.Errorf("%s was not recorded", key)
+				}
+			}
+		})
+	}
+}
+
+func TestWithIdempotencyAcrossPartitions(t *testing.T) {
+	store := newMapStore()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	o := newOptions([]Option{
+		WithIdempotency(store),
+		// spread copies of one message over partitions, as a key that
+		// changed between redeliveries would
+		WithPartitions(4, func(d amqp.Delivery) string { return d.CorrelationId }),
+	})
+	handle := deliveryHandler("test-idempotency-partitions", o,
+		func(context.Context, *int) AckType {
+			calls.Add(1)
+			<-release
+			return Ack
+		},
+		bodyDecoder(JSONUnmarshaller[int]))
+
+	msgs := make(chan amqp.Delivery)
+	done := make(chan struct{})
+	go func() {
+		dispatchPartitioned(msgs, o, handle)
+		close(done)
+	}()
+	acker := &settleRecorder{}
+	partitions := make(map[int]bool)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		partitions[partitionFor(key, 4)] = true
+		msgs <- amqp.Delivery{Acknowledger: acker, MessageId: "m-1", CorrelationId: key, Body: []byte(`1`)}
+	}
+	if len(partitions) < 2 {
+		t.Fatalf("the copies all went to one partition")
+	}
+	waitFor(t, "the first copy", func() bool { return calls.Load() == 1 })
+	close(release)
+	close(msgs)
+	<-done
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler ran %d times for one message id", n)
+	}
+}
@@ -0,0 +1,73 @@
+package pubsub
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var pkgLogger atomic.Pointer[slog.Logger]
+
+// SetLogger sets the logger of the package. Consumers pick it up when they
+// start, so call it before subscribing; WithLogger overrides it for one
+// subscription. Until it is called, slog.Default() is used.
+func SetLogger(l *slog.Logger) {
+	pkgLogger.Store(l)
+}
+
+func logger() *slog.Logger {
+	if l := pkgLogger.Load(); l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+// WithLogger gives a subscription its own logger, e.g. one with extra
+// fields or a different level
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// deliveryAttrs are the fields logged for every message
+func deliveryAttrs(d amqp.Delivery) []any {
+	return []any{
+		slog.String("routing_key", d.RoutingKey),
+		slog.String("message_id", d.MessageId),
+		slog.Int("attempt", deliveryAttempt(d)),
+	}
+}
+
+// deliveryAttempt numbers a delivery from 1. Quorum queues count
+// redeliveries exactly; elsewhere a redelivery is at least the second
+// attempt.
+func deliveryAttempt(d amqp.Delivery) int {
+	switch n := d.Headers["x-delivery-count"].(type) {
+	case int64:
+		return int(n) + 1
+	case int32:
+		return int(n) + 1
+	case int:
+		return n + 1
+	}
+	if d.Redelivered {
+		return 2
+	}
+	return 1
+}
+
+// logSettled logs the outcome of a message: acks only at debug level so
+// busy queues do not flood the logs, requeues at info and discards at warn
+func logSettled(l *slog.Logger, d amqp.Delivery, outcome AckType) {
+	level := slog.LevelDebug
+	switch outcome {
+	case Requeue:
+		level = slog.LevelInfo
+	case Discard:
+		level = slog.LevelWarn
+	}
+	l.Log(context.Background(), level, "message settled", append(deliveryAttrs(d), slog.String("outcome", outcome.String()))...)
+}
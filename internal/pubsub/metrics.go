@@ -0,0 +1,80 @@
+package pubsub
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/metrics"
+)
+
+// Metrics every consumer and publisher reports to metrics.Default. The
+// outcome label of messagesSettled is ack, requeue or discard, plus
+// decode_error for bodies the unmarshaller rejected and duplicate for
+// messages skipped by WithIdempotency.
+var (
+	messagesConsumed = metrics.Default.NewCounterVec("pubsub_messages_consumed_total",
+		"Messages delivered to a consumer.", "queue")
+	messagesSettled = metrics.Default.NewCounterVec("pubsub_messages_settled_total",
+		"Messages settled by a consumer, by outcome.", "queue", "outcome")
+	handlerDuration = metrics.Default.NewHistogramVec("pubsub_handler_duration_seconds",
+		"Time spent in the handler per message or batch.", nil, "queue")
+
+	published = metrics.Default.NewCounterVec("pubsub_published_total",
+		"Messages handed to the broker, by result.", "exchange", "result")
+	publishDuration = metrics.Default.NewHistogramVec("pubsub_publish_duration_seconds",
+		"Time to publish a message, up to the confirm for confirmed publishes.", nil, "exchange")
+	confirmFailures = metrics.Default.NewCounterVec("pubsub_confirm_failures_total",
+		"Confirmed publishes that were nacked or lost with their channel.", "exchange")
+
+	connections = metrics.Default.NewCounterVec("pubsub_connections_total",
+		"Connections opened to RabbitMQ; more than one per process means it reconnected.")
+	connectionsLost = metrics.Default.NewCounterVec("pubsub_connections_lost_total",
+		"Connections closed by an error rather than by the application.")
+	channelReopens = metrics.Default.NewCounterVec("pubsub_channel_reopens_total",
+		"Channels reopened after the broker closed them.", "component")
+
+	limiterThrottled = metrics.Default.NewCounterVec("pubsub_rate_limit_throttled_total",
+		"Messages that had to wait for a rate limiter token.", "limiter")
+	limiterWait = metrics.Default.NewCounterVec("pubsub_rate_limit_wait_seconds_total",
+		"Time spent waiting for rate limiter tokens.", "limiter")
+	limiterRate = metrics.Default.NewGaugeVec("pubsub_rate_limit_per_second",
+		"Current rate limiter limit; 0 or less means unlimited.", "limiter")
+	limiterBurst = metrics.Default.NewGaugeVec("pubsub_rate_limit_burst",
+		"Current rate limiter burst size.", "limiter")
+
+	circuitState = metrics.Default.NewGaugeVec("pubsub_circuit_state",
+		"Current circuit breaker state: 0 closed, 1 open, 2 half-open.", "breaker")
+	circuitTransitions = metrics.Default.NewCounterVec("pubsub_circuit_transitions_total",
+		"Circuit breaker state changes, by the state moved to.", "breaker", "to")
+	circuitRejected = metrics.Default.NewCounterVec("pubsub_circuit_rejected_total",
+		"Messages requeued without running the handler while the circuit was open.", "breaker")
+)
+
+// last successful and failed publish, as unix nanoseconds
+var lastPublishOK, lastPublishFailed atomic.Int64
+
+// LastPublish returns when a publish last succeeded and last failed; zero
+// times mean it never happened. Health checks use it to notice a producer
+// that keeps failing.
+func LastPublish() (succeeded, failed time.Time) {
+	return unixNano(lastPublishOK.Load()), unixNano(lastPublishFailed.Load())
+}
+
+func unixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// observePublish records the outcome of a publish
+func observePublish(exchange string, start time.Time, err error) {
+	publishDuration.With(exchange).Observe(time.Since(start).Seconds())
+	if err != nil {
+		published.With(exchange, "error").Inc()
+		lastPublishFailed.Store(time.Now().UnixNano())
+		return
+	}
+	published.With(exchange, "ok").Inc()
+	lastPublishOK.Store(time.Now().UnixNano())
+}
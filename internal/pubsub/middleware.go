@@ -1,7 +1,7 @@
 package pubsub
 
 import (
-	"log"
+	"sync"
 	"time"
 )
 
@@ -15,11 +15,207 @@ func RetryMiddleware[T any](maxRetries int, delay time.Duration, handler func(*T
 				return Ack
 			}
 			if attempt < maxRetries-1 {
-				log.Printf("⚠️  Handler failed (attempt %d/%d), retrying in %v...", attempt+1, maxRetries, delay)
+				logger().Warn("handler failed, retrying", "attempt", attempt+1, "max_attempts", maxRetries, "delay", delay)
 				time.Sleep(delay)
 			}
 		}
-		log.Printf("❌ Handler failed after %d attempts, discarding message", maxRetries)
+		logger().Error("handler failed on every attempt, discarding message", "attempts", maxRetries)
 		return Discard
 	}
 }
+
+// CircuitState is the state of a CircuitBreaker
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // handler runs normally
+	CircuitOpen                         // handler is skipped, messages are requeued
+	CircuitHalfOpen                     // one probe message is let through
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker stops calling a failing downstream. After
+// FailureThreshold consecutive failures it opens; while open, messages are
+// requeued without running the handler. After OpenTimeout it half-opens
+// and lets a single probe through, which closes it again on success or
+// re-opens it on failure. Calls that started before the circuit opened do
+// not count once it has: only the probe decides.
+//
+// The state, transitions and rejections are exported to metrics.Default
+// labelled with Name.
+type CircuitBreaker struct {
+	Name             string
+	FailureThreshold int
+	OpenTimeout      time.Duration
+	// RequeueDelay slows down redelivery while open so the consumer does
+	// not spin on requeued messages
+	RequeueDelay time.Duration
+	// IsFailure decides which handler results count as failures
+	// (default: anything but Ack)
+	IsFailure func(AckType) bool
+	// OnStateChange is called on every transition, e.g. to alert or to
+	// pause the subscription
+	OnStateChange func(from, to CircuitState)
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+	stats    CircuitStats
+}
+
+// CircuitStats counts what a CircuitBreaker did
+type CircuitStats struct {
+	Opened   int // times the circuit opened
+	Rejected int // messages requeued while open
+}
+
+// NewCircuitBreaker creates a closed breaker
+func NewCircuitBreaker(name string, failureThreshold int, openTimeout time.Duration) *CircuitBreaker {
+	cb := &CircuitBreaker{
+		Name:             name,
+		FailureThreshold: failureThreshold,
+		OpenTimeout:      openTimeout,
+		RequeueDelay:     time.Second,
+	}
+	circuitState.With(name).Set(float64(CircuitClosed))
+	return cb
+}
+
+// State returns the current state
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// Stats returns the breaker's counters
+func (cb *CircuitBreaker) Stats() CircuitStats {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.stats
+}
+
+// allow reports whether a call may go through, moving an expired open
+// circuit to half-open. probe is true for the single call let through
+// while half-open, whose outcome closes or re-opens the circuit.
+func (cb *CircuitBreaker) allow() (allowed, probe bool) {
+	cb.mu.Lock()
+	from := cb.state
+	allowed = true
+	switch cb.state {
+	case CircuitOpen:
+		if time.Since(cb.openedAt) < cb.OpenTimeout {
+			allowed = false
+		} else {
+			cb.setState(CircuitHalfOpen)
+			cb.probing, probe = true, true
+		}
+	case CircuitHalfOpen:
+		if cb.probing {
+			allowed = false
+		} else {
+			cb.probing, probe = true, true
+		}
+	}
+	if !allowed {
+		cb.stats.Rejected++
+		circuitRejected.With(cb.Name).Inc()
+	}
+	to := cb.state
+	cb.mu.Unlock()
+
+	cb.notify(from, to)
+	return allowed, probe
+}
+
+// record feeds the outcome of an allowed call back into the breaker
+func (cb *CircuitBreaker) record(failed, probe bool) {
+	cb.mu.Lock()
+	from := cb.state
+	switch {
+	case probe:
+		cb.probing = false
+		if failed {
+			cb.open()
+		} else {
+			cb.failures = 0
+			cb.setState(CircuitClosed)
+		}
+	case cb.state != CircuitClosed:
+		// let through before the circuit opened; the probe decides
+	case !failed:
+		cb.failures = 0
+	default:
+		cb.failures++
+		if cb.failures >= cb.FailureThreshold {
+			cb.open()
+		}
+	}
+	to := cb.state
+	cb.mu.Unlock()
+
+	cb.notify(from, to)
+}
+
+// open must be called with mu held
+func (cb *CircuitBreaker) open() {
+	cb.setState(CircuitOpen)
+	cb.openedAt = time.Now()
+	cb.stats.Opened++
+}
+
+// setState moves to state and exports it; must be called with mu held
+func (cb *CircuitBreaker) setState(state CircuitState) {
+	if state == cb.state {
+		return
+	}
+	cb.state = state
+	circuitState.With(cb.Name).Set(float64(state))
+	circuitTransitions.With(cb.Name, state.String()).Inc()
+}
+
+// notify reports a transition; it runs without mu held so OnStateChange
+// may call back into the breaker
+func (cb *CircuitBreaker) notify(from, to CircuitState) {
+	if from == to {
+		return
+	}
+	logger().Warn("circuit breaker changed state", "breaker", cb.Name, "from", from.String(), "to", to.String())
+	if cb.OnStateChange != nil {
+		cb.OnStateChange(from, to)
+	}
+}
+
+// CircuitBreakerMiddleware wraps a handler with cb. While the circuit is
+// open, messages are requeued after cb.RequeueDelay instead of reaching
+// the handler. A breaker can be shared by several subscriptions that call
+// the same downstream.
+func CircuitBreakerMiddleware[T any](cb *CircuitBreaker, handler func(*T) AckType) func(*T) AckType {
+	return func(msg *T) AckType {
+		allowed, probe := cb.allow()
+		if !allowed {
+			time.Sleep(cb.RequeueDelay)
+			return Requeue
+		}
+		result := handler(msg)
+		isFailure := cb.IsFailure
+		if isFailure == nil {
+			isFailure = func(a AckType) bool { return a != Ack }
+		}
+		cb.record(isFailure(result), probe)
+		return result
+	}
+}
@@ -0,0 +1,307 @@
+package pubsub
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/metrics"
+)
+
+// breakerStep is one message through CircuitBreakerMiddleware: the result
+// the handler returns if it runs, whether it should run, and the state of
+// the breaker afterwards. wait sleeps past the open timeout first.
+type breakerStep struct {
+	wait   bool
+	result AckType
+	called bool
+	state  CircuitState
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+	tests := []struct {
+		name        string
+		threshold   int
+		isFailure   func(AckType) bool
+		steps       []breakerStep
+		wantOpened  int
+		wantRejects int
+	}{
+		{
+			name:      "opens after consecutive failures",
+			threshold: 3,
+			steps: []breakerStep{
+				{result: Discard, called: true, state: CircuitClosed},
+				{result: Requeue, called: true, state: CircuitClosed},
+				{result: Discard, called: true, state: CircuitOpen},
+				{result: Ack, called: false, state: CircuitOpen},
+				{result: Ack, called: false, state: CircuitOpen},
+			},
+			wantOpened:  1,
+			wantRejects: 2,
+		},
+		{
+			name:      "success resets the failure count",
+			threshold: 3,
+			steps: []breakerStep{
+				{result: Discard, called: true, state: CircuitClosed},
+				{result: Discard, called: true, state: CircuitClosed},
+				{result: Ack, called: true, state: CircuitClosed},
+				{result: Discard, called: true, state: CircuitClosed},
+				{result: Discard, called: true, state: CircuitClosed},
+			},
+		},
+		{
+			name:      "successful probe closes",
+			threshold: 1,
+			steps: []breakerStep{
+				{result: Discard, called: true, state: CircuitOpen},
+				{result: Ack, called: false, state: CircuitOpen},
+				{wait: true, result: Ack, called: true, state: CircuitClosed},
+				{result: Discard, called: true, state: CircuitOpen},
+			},
+			wantOpened:  2,
+			wantRejects: 1,
+		},
+		{
+			name:      "failed probe reopens",
+			threshold: 2,
+			steps: []breakerStep{
+				{result: Discard, called: true, state: CircuitClosed},
+				{result: Discard, called: true, state: CircuitOpen},
+				{wait: true, result: Discard, called: true, state: CircuitOpen},
+				{result: Ack, called: false, state: CircuitOpen},
+				{wait: true, result: Ack, called: true, state: CircuitClosed},
+			},
+			wantOpened:  2,
+			wantRejects: 1,
+		},
+		{
+			name:      "custom failure predicate",
+			threshold: 1,
+			isFailure: func(a AckType) bool { return a == Discard },
+			steps: []breakerStep{
+				{result: Requeue, called: true, state: CircuitClosed},
+				{result: Requeue, called: true, state: CircuitClosed},
+				{result: Discard, called: true, state: CircuitOpen},
+			},
+			wantOpened: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := NewCircuitBreaker("test-"+tt.name, tt.threshold, timeout)
+			cb.RequeueDelay = 0
+			cb.IsFailure = tt.isFailure
+
+			var result AckType
+			called := false
+			handler := CircuitBreakerMiddleware(cb, func(*int) AckType {
+				called = true
+				return result
+			})
+			for i, step := range tt.steps {
+				if step.wait {
+					time.Sleep(2 * timeout)
+				}
+				result, called = step.result, false
+				got := handler(new(int))
+				if called != step.called {
+					t.Fatalf("step %d: handler called = %v, want %v", i, called, step.called)
+				}
+				if !called && got != Requeue {
+					t.Errorf("step %d: rejected message returned %v, want requeue", i, got)
+				}
+				if called && got != step.result {
+					t.Errorf("step %d: returned %v, want the handler's %v", i, got, step.result)
+				}
+				if s := cb.State(); s != step.state {
+					t.Fatalf("step %d: state = %v, want %v", i, s, step.state)
+				}
+			}
+			if st := cb.Stats(); st.Opened != tt.wantOpened || st.Rejected != tt.wantRejects {
+				t.Errorf("stats = %+v, want opened %d, rejected %d", st, tt.wantOpened, tt.wantRejects)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	cb := NewCircuitBreaker("test-single-probe", 1, 10*time.Millisecond)
+	cb.RequeueDelay = 0
+
+	var transitions []string
+	var mu sync.Mutex
+	cb.OnStateChange = func(from, to CircuitState) {
+		// callbacks run without the lock held and may call back in
+		_ = cb.State()
+		mu.Lock()
+		transitions = append(transitions, from.String()+">"+to.String())
+		mu.Unlock()
+	}
+
+	release := make(chan struct{})
+	var calls atomic.Int32
+	fail := true
+	handler := CircuitBreakerMiddleware(cb, func(*int) AckType {
+		calls.Add(1)
+		if fail {
+			return Discard
+		}
+		<-release
+		return Ack
+	})
+	handler(new(int))
+	fail = false
+	time.Sleep(20 * time.Millisecond)
+
+	// many messages arrive once the timeout expired: one probe gets through
+	var wg sync.WaitGroup
+	results := make(chan AckType, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- handler(new(int))
+		}()
+	}
+	waitFor(t, "the probe", func() bool { return calls.Load() == 2 })
+	waitFor(t, "the rejections", func() bool { return len(results) == 9 })
+	close(release)
+	wg.Wait()
+	close(results)
+
+	acked := 0
+	for r := range results {
+		if r == Ack {
+			acked++
+		}
+	}
+	if acked != 1 || calls.Load() != 2 {
+		t.Errorf("%d probes acked, handler called %d times; want a single probe", acked, calls.Load()-1)
+	}
+	if s := cb.State(); s != CircuitClosed {
+		t.Errorf("state = %v, want closed", s)
+	}
+	want := []string{"closed>open", "open>half-open", "half-open>closed"}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(transitions, want) {
+		t.Errorf("transitions = %q, want %q", transitions, want)
+	}
+}
+
+func TestCircuitBreakerIgnoresCallsFromBeforeItOpened(t *testing.T) {
+	const timeout = 10 * time.Millisecond
+	tests := []struct {
+		name      string
+		stale     AckType // result of the call let through while closed
+		probe     AckType
+		wantState CircuitState
+	}{
+		{"stale success does not close", Ack, Discard, CircuitOpen},
+		{"stale failure does not reopen", Discard, Ack, CircuitClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := NewCircuitBreaker("test-stale-"+tt.name, 1, timeout)
+			cb.RequeueDelay = 0
+
+			// each message carries the channel its result arrives on
+			running := make(chan struct{})
+			handler := CircuitBreakerMiddleware(cb, func(result *chan AckType) AckType {
+				running <- struct{}{}
+				return <-*result
+			})
+			start := func() (chan AckType, chan struct{}) {
+				result, done := make(chan AckType), make(chan struct{})
+				go func() {
+					handler(&result)
+					close(done)
+				}()
+				<-running
+				return result, done
+			}
+
+			// a slow call starts while closed, then another one opens it
+			stale, staleDone := start()
+			failing, failingDone := start()
+			failing <- Discard
+			<-failingDone
+			if s := cb.State(); s != CircuitOpen {
+				t.Fatalf("state = %v, want open", s)
+			}
+
+			// the probe is let through and still running when the slow call ends
+			time.Sleep(2 * timeout)
+			probe, probeDone := start()
+			stale <- tt.stale
+			<-staleDone
+			if s := cb.State(); s != CircuitHalfOpen {
+				t.Fatalf("state after the stale call = %v, want half-open", s)
+			}
+			probe <- tt.probe
+			<-probeDone
+			if s := cb.State(); s != tt.wantState {
+				t.Errorf("state after the probe = %v, want %v", s, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerMetrics(t *testing.T) {
+	sample := func(name, labels string) float64 {
+		for _, s := range metrics.Default.Gather() {
+			if s.Name == name && s.Labels == labels {
+				return s.Value
+			}
+		}
+		return 0
+	}
+	const name = "test-metrics"
+	breaker := `{breaker="` + name + `"}`
+	transitions := func(to string) float64 {
+		return sample("pubsub_circuit_transitions_total", strings.TrimSuffix(breaker, "}")+`,to="`+to+`"}`)
+	}
+	// the counters outlive the breaker when the test runs again
+	rejectedBefore := sample("pubsub_circuit_rejected_total", breaker)
+	transitionsBefore := map[string]float64{}
+	for _, to := range []string{"open", "half-open", "closed"} {
+		transitionsBefore[to] = transitions(to)
+	}
+
+	cb := NewCircuitBreaker(name, 1, 10*time.Millisecond)
+	cb.RequeueDelay = 0
+	if s := sample("pubsub_circuit_state", breaker); s != float64(CircuitClosed) {
+		t.Errorf("state gauge of a new breaker = %v, want 0", s)
+	}
+	var result AckType
+	handler := CircuitBreakerMiddleware(cb, func(*int) AckType { return result })
+
+	result = Discard
+	handler(new(int))
+	handler(new(int))
+	handler(new(int))
+	if s := sample("pubsub_circuit_state", breaker); s != float64(CircuitOpen) {
+		t.Errorf("state gauge while open = %v, want 1", s)
+	}
+	if n := sample("pubsub_circuit_rejected_total", breaker) - rejectedBefore; n != 2 {
+		t.Errorf("rejected = %v, want 2", n)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	result = Ack
+	handler(new(int))
+	if s := sample("pubsub_circuit_state", breaker); s != float64(CircuitClosed) {
+		t.Errorf("state gauge after the probe = %v, want 0", s)
+	}
+	for to, before := range transitionsBefore {
+		if n := transitions(to) - before; n != 1 {
+			t.Errorf("transitions to %s = %v, want 1", to, n)
+		}
+	}
+}
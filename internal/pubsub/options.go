@@ -0,0 +1,245 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Option tweaks how DeclareAndBind declares a queue and how Subscribe
+// consumes from it.
+type Option func(*options)
+
+type options struct {
+	deliveryLimit    int
+	streamOffset     any
+	queue            QueueOptions
+	prefetch         int
+	priorityFirst    bool
+	consumerPriority int
+	idempotency      IdempotencyStore
+	partitions       int
+	partitionKey     func(amqp.Delivery) string
+	limiters         []*RateLimiter
+	logger           *slog.Logger
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{prefetch: 10}
+	for _, opt := range opts {
+		opt(o)
+	}
+	// applied last so that WithPrefetch cannot undo it, whatever the order
+	if o.priorityFirst {
+		o.prefetch = 1
+	}
+	if o.logger == nil {
+		o.logger = logger()
+	}
+	return o
+}
+
+// WithDeliveryLimit sets x-delivery-limit on a Quorum queue: a message that
+// is redelivered more than n times is dead-lettered instead of requeued,
+// so a poison message cannot loop forever. Other queue types reject it.
+func WithDeliveryLimit(n int) Option {
+	return func(o *options) {
+		o.deliveryLimit = n
+	}
+}
+
+// OverflowMode is the x-overflow behaviour once a queue reaches its length limit
+type OverflowMode string
+
+const (
+	OverflowDropHead         OverflowMode = "drop-head"          // drop (or dead-letter) the oldest messages
+	OverflowRejectPublish    OverflowMode = "reject-publish"     // nack new publishes
+	OverflowRejectPublishDLX OverflowMode = "reject-publish-dlx" // nack and dead-letter new publishes (classic only)
+)
+
+// QueueMode is the x-queue-mode of a classic queue
+type QueueMode string
+
+const (
+	QueueModeDefault QueueMode = "default"
+	QueueModeLazy    QueueMode = "lazy" // keep messages on disk as early as possible
+)
+
+// QueueOptions are the typed queue arguments DeclareAndBind merges with the
+// dead-letter settings. Zero values leave the argument unset.
+type QueueOptions struct {
+	MaxLength      int           // x-max-length, in messages
+	MaxLengthBytes int           // x-max-length-bytes, total body size
+	Overflow       OverflowMode  // x-overflow, what happens once a limit is hit
+	MessageTTL     time.Duration // x-message-ttl, expired messages are dead-lettered
+	Expires        time.Duration // x-expires, delete the queue after being unused this long
+	QueueMode      QueueMode     // x-queue-mode, classic queues only
+	MaxPriority    uint8         // x-max-priority, enables message priorities 0..MaxPriority
+
+	// SingleActiveConsumer (x-single-active-consumer) lets only one
+	// consumer receive messages at a time, the others stand by
+	SingleActiveConsumer bool
+}
+
+// WithQueueOptions sets length limits, TTLs and the queue mode at declare
+// time. DeclareAndBind returns an error for options the queue type does
+// not support: QueueMode, MaxPriority and reject-publish-dlx on Quorum
+// queues, and anything but MaxLengthBytes on Stream queues.
+func WithQueueOptions(q QueueOptions) Option {
+	return func(o *options) {
+		o.queue = q
+	}
+}
+
+// apply writes the non-zero options into table
+func (q QueueOptions) apply(table amqp.Table) {
+	if q.MaxLength > 0 {
+		table["x-max-length"] = q.MaxLength
+	}
+	if q.MaxLengthBytes > 0 {
+		table["x-max-length-bytes"] = q.MaxLengthBytes
+	}
+	if q.Overflow != "" {
+		table["x-overflow"] = string(q.Overflow)
+	}
+	if q.MessageTTL > 0 {
+		table["x-message-ttl"] = q.MessageTTL.Milliseconds()
+	}
+	if q.Expires > 0 {
+		table["x-expires"] = q.Expires.Milliseconds()
+	}
+	if q.QueueMode != "" {
+		table["x-queue-mode"] = string(q.QueueMode)
+	}
+	if q.MaxPriority > 0 {
+		table["x-max-priority"] = q.MaxPriority
+	}
+	if q.SingleActiveConsumer {
+		table["x-single-active-consumer"] = true
+	}
+}
+
+// WithPrefetch sets how many unacked messages the consumer may hold
+// (default 10). It has no effect together with WithPriorityFirst.
+func WithPrefetch(n int) Option {
+	return func(o *options) {
+		o.prefetch = n
+	}
+}
+
+// WithPriorityFirst makes a consumer of a priority queue prefer urgent
+// messages under backlog. The broker only orders messages by priority
+// while they sit in the queue, so the prefetch is dropped to 1 to keep
+// low priority messages from being buffered ahead of urgent ones, and
+// the consumer registers with x-priority so it is served before
+// lower priority consumers of the same queue. The prefetch of 1 wins
+// over WithPrefetch in any order; WithPartitions still raises it to one
+// message per partition.
+func WithPriorityFirst(consumerPriority int) Option {
+	return func(o *options) {
+		o.priorityFirst = true
+		o.consumerPriority = consumerPriority
+	}
+}
+
+// StreamOffset selects where a consumer of a Stream queue starts reading
+type StreamOffset struct {
+	value any
+}
+
+var (
+	// OffsetFirst replays the stream from the first retained message
+	OffsetFirst = StreamOffset{"first"}
+	// OffsetLast starts at the last written chunk of messages
+	OffsetLast = StreamOffset{"last"}
+	// OffsetNext only delivers messages published after subscribing (the broker default)
+	OffsetNext = StreamOffset{"next"}
+)
+
+// OffsetAt starts reading at an absolute stream offset
+func OffsetAt(offset int64) StreamOffset {
+	return StreamOffset{offset}
+}
+
+// OffsetTimestamp starts reading at the first chunk published at or after t
+func OffsetTimestamp(t time.Time) StreamOffset {
+	return StreamOffset{t}
+}
+
+// WithStreamOffset sets x-stream-offset when consuming from a Stream queue
+func WithStreamOffset(offset StreamOffset) Option {
+	return func(o *options) {
+		o.streamOffset = offset.value
+	}
+}
+
+// validate rejects options the broker refuses for queueType, so they fail
+// before anything is declared instead of with PRECONDITION_FAILED
+func (o *options) validate(queueType SimpleQueueType) error {
+	q := o.queue
+	if o.deliveryLimit > 0 && queueType != Quorum {
+		return errors.New("x-delivery-limit is only supported on quorum queues")
+	}
+	switch queueType {
+	case Quorum:
+		if q.QueueMode != "" {
+			return fmt.Errorf("quorum queues do not support x-queue-mode %s", q.QueueMode)
+		}
+		if q.MaxPriority > 0 {
+			return errors.New("quorum queues do not support x-max-priority")
+		}
+		if q.Overflow == OverflowRejectPublishDLX {
+			return fmt.Errorf("quorum queues do not support x-overflow %s", q.Overflow)
+		}
+	case Stream:
+		// everything but the size limit would be dropped, see queueArgs
+		if q != (QueueOptions{MaxLengthBytes: q.MaxLengthBytes}) {
+			return errors.New("stream queues only support the MaxLengthBytes queue option")
+		}
+	}
+	return nil
+}
+
+// queueArgs builds the x-arguments for declaring a queue of the given type
+func queueArgs(queueType SimpleQueueType, o *options) (amqp.Table, error) {
+	if err := o.validate(queueType); err != nil {
+		return nil, err
+	}
+	table := amqp.Table{}
+	switch queueType {
+	case Quorum:
+		table["x-queue-type"] = "quorum"
+		if o.deliveryLimit > 0 {
+			table["x-delivery-limit"] = o.deliveryLimit
+		}
+	case Stream:
+		// streams keep messages after consumption and do not support
+		// dead-lettering; retention is only bounded by size
+		table["x-queue-type"] = "stream"
+		if o.queue.MaxLengthBytes > 0 {
+			table["x-max-length-bytes"] = o.queue.MaxLengthBytes
+		}
+		return table, nil
+	}
+	o.queue.apply(table)
+	table["x-dead-letter-exchange"] = "peril_dlx"
+	return table, nil
+}
+
+// consumeArgs builds the x-arguments for basic.consume
+func consumeArgs(queueType SimpleQueueType, o *options) amqp.Table {
+	table := amqp.Table{}
+	if queueType == Stream && o.streamOffset != nil {
+		table["x-stream-offset"] = o.streamOffset
+	}
+	if o.consumerPriority != 0 {
+		table["x-priority"] = o.consumerPriority
+	}
+	if len(table) == 0 {
+		return nil
+	}
+	return table
+}
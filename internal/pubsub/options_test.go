@@ -0,0 +1,190 @@
+package pubsub
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestPriorityFirstPrefetch(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         []Option
+		wantPrefetch int
+	}{
+		{"default", nil, 10},
+		{"prefetch", []Option{WithPrefetch(64)}, 64},
+		{"priority first", []Option{WithPriorityFirst(10)}, 1},
+		{"prefetch after priority first", []Option{WithPriorityFirst(10), WithPrefetch(64)}, 1},
+		{"prefetch before priority first", []Option{WithPrefetch(64), WithPriorityFirst(10)}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOptions(tt.opts)
+			if o.prefetch != tt.wantPrefetch {
+				t.Errorf("prefetch = %d, want %d", o.prefetch, tt.wantPrefetch)
+			}
+		})
+	}
+}
+
+func TestQueueArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		queueType SimpleQueueType
+		opts      []Option
+		want      amqp.Table
+	}{
+		{
+			name:      "classic defaults to the DLX",
+			queueType: Durable,
+			want:      amqp.Table{"x-dead-letter-exchange": "peril_dlx"},
+		},
+		{
+			name:      "queue options merged with the DLX",
+			queueType: Durable,
+			opts: []Option{WithQueueOptions(QueueOptions{
+				MaxLength:      1000,
+				MaxLengthBytes: 1 << 20,
+				Overflow:       OverflowRejectPublishDLX,
+				MessageTTL:     90 * time.Second,
+				Expires:        time.Hour,
+				QueueMode:      QueueModeLazy,
+				MaxPriority:    9,
+			})},
+			want: amqp.Table{
+				"x-max-length":           1000,
+				"x-max-length-bytes":     1 << 20,
+				"x-overflow":             "reject-publish-dlx",
+				"x-message-ttl":          int64(90000),
+				"x-expires":              int64(3600000),
+				"x-queue-mode":           "lazy",
+				"x-max-priority":         uint8(9),
+				"x-dead-letter-exchange": "peril_dlx",
+			},
+		},
+		{
+			name:      "quorum with a delivery limit",
+			queueType: Quorum,
+			opts:      []Option{WithDeliveryLimit(5)},
+			want: amqp.Table{
+				"x-queue-type":           "quorum",
+				"x-delivery-limit":       5,
+				"x-dead-letter-exchange": "peril_dlx",
+			},
+		},
+		{
+			name:      "stream is size bounded and has no DLX",
+			queueType: Stream,
+			opts:      []Option{WithQueueOptions(QueueOptions{MaxLengthBytes: 5 << 30})},
+			want: amqp.Table{
+				"x-queue-type":       "stream",
+				"x-max-length-bytes": 5 << 30,
+			},
+		},
+		{
+			name:      "stream without options",
+			queueType: Stream,
+			want:      amqp.Table{"x-queue-type": "stream"},
+		},
+		{
+			name:      "quorum with supported queue options",
+			queueType: Quorum,
+			opts: []Option{WithQueueOptions(QueueOptions{
+				MaxLength:            10,
+				Overflow:             OverflowRejectPublish,
+				SingleActiveConsumer: true,
+			})},
+			want: amqp.Table{
+				"x-queue-type":             "quorum",
+				"x-max-length":             10,
+				"x-overflow":               "reject-publish",
+				"x-single-active-consumer": true,
+				"x-dead-letter-exchange":   "peril_dlx",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := queueArgs(tt.queueType, newOptions(tt.opts))
+			if err != nil {
+				t.Fatalf("queueArgs: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("queueArgs = %v, want %v", got, tt.want)
+			}
+			if err := got.Validate(); err != nil {
+				t.Errorf("queueArgs: %v", err)
+			}
+		})
+	}
+}
+
+func TestQueueArgsRejectsUnsupportedOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		queueType SimpleQueueType
+		opts      []Option
+	}{
+		{"lazy quorum", Quorum, []Option{WithQueueOptions(QueueOptions{QueueMode: QueueModeLazy})}},
+		{"quorum priority", Quorum, []Option{WithQueueOptions(QueueOptions{MaxPriority: 9})}},
+		{"quorum reject-publish-dlx", Quorum, []Option{WithQueueOptions(QueueOptions{Overflow: OverflowRejectPublishDLX})}},
+		{"lazy stream", Stream, []Option{WithQueueOptions(QueueOptions{QueueMode: QueueModeLazy})}},
+		{"stream priority", Stream, []Option{WithQueueOptions(QueueOptions{MaxPriority: 9})}},
+		{"stream reject-publish-dlx", Stream, []Option{WithQueueOptions(QueueOptions{Overflow: OverflowRejectPublishDLX})}},
+		{"stream message ttl", Stream, []Option{WithQueueOptions(QueueOptions{MessageTTL: time.Minute})}},
+		{"classic delivery limit", Durable, []Option{WithDeliveryLimit(5)}},
+		{"stream delivery limit", Stream, []Option{WithDeliveryLimit(5)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if table, err := queueArgs(tt.queueType, newOptions(tt.opts)); err == nil {
+				t.Errorf("queueArgs = %v, want an error", table)
+			}
+			// DeclareAndBind checks before it touches conn
+			if _, _, err := DeclareAndBind(nil, "x", "q", "k", tt.queueType, tt.opts...); err == nil {
+				t.Error("DeclareAndBind accepted the options")
+			}
+		})
+	}
+}
+
+func TestConsumeArgs(t *testing.T) {
+	since := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		queueType SimpleQueueType
+		opts      []Option
+		want      amqp.Table
+	}{
+		{"no arguments", Durable, nil, nil},
+		{"stream without an offset", Stream, nil, nil},
+		{"first", Stream, []Option{WithStreamOffset(OffsetFirst)}, amqp.Table{"x-stream-offset": "first"}},
+		{"last", Stream, []Option{WithStreamOffset(OffsetLast)}, amqp.Table{"x-stream-offset": "last"}},
+		{"next", Stream, []Option{WithStreamOffset(OffsetNext)}, amqp.Table{"x-stream-offset": "next"}},
+		{"numeric offset", Stream, []Option{WithStreamOffset(OffsetAt(4200))}, amqp.Table{"x-stream-offset": int64(4200)}},
+		{"timestamp", Stream, []Option{WithStreamOffset(OffsetTimestamp(since))}, amqp.Table{"x-stream-offset": since}},
+		{"offset ignored on other queues", Quorum, []Option{WithStreamOffset(OffsetFirst)}, nil},
+		{"consumer priority", Durable, []Option{WithPriorityFirst(10)}, amqp.Table{"x-priority": 10}},
+		{
+			name:      "offset and priority",
+			queueType: Stream,
+			opts:      []Option{WithStreamOffset(OffsetAt(7)), WithPriorityFirst(3)},
+			want:      amqp.Table{"x-stream-offset": int64(7), "x-priority": 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := consumeArgs(tt.queueType, newOptions(tt.opts))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("consumeArgs = %v, want %v", got, tt.want)
+			}
+			// the table must be encodable in a basic.consume frame
+			if err := got.Validate(); err != nil {
+				t.Errorf("consumeArgs: %v", err)
+			}
+		})
+	}
+}
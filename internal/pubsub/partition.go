@@ -0,0 +1,68 @@
+package pubsub
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/abdooman21/ecom-plat/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// WithPartitions processes deliveries on n workers. Each delivery is
+// hashed by key to a fixed worker, so messages with the same key (e.g. the
+// created, paid and shipped events of one order) are handled one at a
+// time and in queue order, while different keys run in parallel.
+// The prefetch is raised to at least n. A nil key partitions by the full
+// routing key.
+//
+// A Requeue puts the message back at the head of the queue and can still
+// let a later message for the same key overtake it; prefer retrying in
+// the handler (RetryMiddleware) when strict ordering matters.
+func WithPartitions(n int, key func(amqp.Delivery) string) Option {
+	if key == nil {
+		key = func(d amqp.Delivery) string { return d.RoutingKey }
+	}
+	return func(o *options) {
+		o.partitions = n
+		o.partitionKey = key
+	}
+}
+
+// OrderIDKey partitions order messages by the order id in their routing
+// key (order.{region}.{orderID})
+func OrderIDKey(d amqp.Delivery) string {
+	return routing.OrderIDFromKey(d.RoutingKey)
+}
+
+// dispatchPartitioned fans deliveries out to one goroutine per partition.
+// Each partition buffers up to the prefetch count so a busy partition
+// never blocks the dispatcher while the others are idle. It returns once
+// msgs is closed and every worker has drained its backlog.
+func dispatchPartitioned(msgs <-chan amqp.Delivery, o *options, handle func(amqp.Delivery)) {
+	var wg sync.WaitGroup
+	workers := make([]chan amqp.Delivery, o.partitions)
+	for i := range workers {
+		workers[i] = make(chan amqp.Delivery, max(o.prefetch, o.partitions))
+		wg.Add(1)
+		go func(in <-chan amqp.Delivery) {
+			defer wg.Done()
+			for d := range in {
+				handle(d)
+			}
+		}(workers[i])
+	}
+
+	for d := range msgs {
+		workers[partitionFor(o.partitionKey(d), len(workers))] <- d
+	}
+	for _, w := range workers {
+		close(w)
+	}
+	wg.Wait()
+}
+
+func partitionFor(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
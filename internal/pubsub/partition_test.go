@@ -0,0 +1,161 @@
+package pubsub
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestPartitionFor(t *testing.T) {
+	tests := []struct {
+		key string
+		n   int
+	}{
+		{"", 1},
+		{"ORD-1", 1},
+		{"ORD-1", 4},
+		{"ORD-2", 4},
+		{"order.eu.ORD-3", 16},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.key, tt.n), func(t *testing.T) {
+			p := partitionFor(tt.key, tt.n)
+			if p < 0 || p >= tt.n {
+				t.Fatalf("partitionFor = %d, out of [0,%d)", p, tt.n)
+			}
+			if again := partitionFor(tt.key, tt.n); again != p {
+				t.Errorf("partitionFor not stable: %d then %d", p, again)
+			}
+		})
+	}
+}
+
+func TestOrderIDKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"order.eu.ORD-1", "ORD-1"},
+		{"order.us.urgent", "urgent"},
+		{"user.signup", "user.signup"},
+	}
+	for _, tt := range tests {
+		if got := OrderIDKey(amqp.Delivery{RoutingKey: tt.key}); got != tt.want {
+			t.Errorf("OrderIDKey(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestDispatchPartitionedKeepsOrderPerKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		partitions int
+		keys       int
+		perKey     int
+	}{
+		{"one partition", 1, 5, 20},
+		{"fewer partitions than keys", 4, 10, 50},
+		{"more partitions than keys", 16, 3, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOptions([]Option{WithPartitions(tt.partitions, nil)})
+			msgs := make(chan amqp.Delivery)
+
+			var mu sync.Mutex
+			seen := make(map[string][]int)
+			handle := func(d amqp.Delivery) {
+				mu.Lock()
+				seen[d.RoutingKey] = append(seen[d.RoutingKey], int(d.DeliveryTag))
+				mu.Unlock()
+			}
+
+			done := make(chan struct{})
+			go func() {
+				dispatchPartitioned(msgs, o, handle)
+				close(done)
+			}()
+			for i := 0; i < tt.perKey; i++ {
+				for k := 0; k < tt.keys; k++ {
+					msgs <- amqp.Delivery{RoutingKey: fmt.Sprintf("key-%d", k), DeliveryTag: uint64(i)}
+				}
+			}
+			close(msgs)
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("dispatchPartitioned did not return after msgs closed")
+			}
+
+			if len(seen) != tt.keys {
+				t.Fatalf("handled %d keys, want %d", len(seen), tt.keys)
+			}
+			for key, tags := range seen {
+				if len(tags) != tt.perKey {
+					t.Errorf("%s: handled %d messages, want %d", key, len(tags), tt.perKey)
+				}
+				for i, tag := range tags {
+					if tag != i {
+						t.Errorf("%s: message %d handled at position %d", key, tag, i)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDispatchPartitionedRunsKeysInParallel(t *testing.T) {
+	o := newOptions([]Option{WithPartitions(8, nil)})
+	msgs := make(chan amqp.Delivery)
+
+	// a blocked partition must not stop the others
+	block := make(chan struct{})
+	blockedKey := "blocked"
+	blockedPart := partitionFor(blockedKey, 8)
+	var other string
+	for i := 0; other == ""; i++ {
+		if k := fmt.Sprintf("key-%d", i); partitionFor(k, 8) != blockedPart {
+			other = k
+		}
+	}
+
+	handled := make(chan string, 2)
+	handle := func(d amqp.Delivery) {
+		if d.RoutingKey == blockedKey {
+			<-block
+		}
+		handled <- d.RoutingKey
+	}
+	done := make(chan struct{})
+	go func() {
+		dispatchPartitioned(msgs, o, handle)
+		close(done)
+	}()
+
+	msgs <- amqp.Delivery{RoutingKey: blockedKey}
+	msgs <- amqp.Delivery{RoutingKey: other}
+	select {
+	case k := <-handled:
+		if k != other {
+			t.Fatalf("handled %q first, want %q", k, other)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a blocked partition stalled the others")
+	}
+
+	close(msgs)
+	select {
+	case <-done:
+		t.Fatal("returned before the blocked partition drained")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(block)
+	<-done
+	if k := <-handled; k != blockedKey {
+		t.Errorf("handled %q, want %q", k, blockedKey)
+	}
+}
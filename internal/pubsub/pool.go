@@ -0,0 +1,201 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrPoolClosed is returned by Get once the pool is closed
+var ErrPoolClosed = errors.New("channel pool closed")
+
+// PublishChannel is what the publish helpers publish on. Both
+// *amqp.Channel and *ChannelPool implement it; an *amqp.Channel must not
+// be shared between goroutines, a ChannelPool can.
+type PublishChannel interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// ChannelPool hands out channels of one connection so many goroutines
+// (e.g. HTTP handlers) can publish at once. It opens channels lazily, up
+// to a cap, and replaces channels the broker closed.
+type ChannelPool struct {
+	pool channelPool[*amqp.Channel]
+}
+
+// NewChannelPool creates a pool of at most maxChannels channels on conn
+func NewChannelPool(conn *amqp.Connection, maxChannels int) *ChannelPool {
+	open := func() (*amqp.Channel, error) {
+		return conn.Channel()
+	}
+	return &ChannelPool{pool: newChannelPool(open, maxChannels)}
+}
+
+// Get returns an idle channel, opens a new one if the cap allows, or waits
+// for one to be returned until ctx is done. Channels must be given back
+// with Put.
+func (p *ChannelPool) Get(ctx context.Context) (*amqp.Channel, error) {
+	return p.pool.get(ctx)
+}
+
+// Put returns a channel to the pool. Closed channels are dropped and
+// their slot freed.
+func (p *ChannelPool) Put(ch *amqp.Channel) {
+	p.pool.put(ch)
+}
+
+// PublishWithContext publishes on a pooled channel, so a ChannelPool can be
+// passed to PublishJSON, PubJSONwithCTX and PubGob from any goroutine
+func (p *ChannelPool) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	return p.pool.publish(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+// Close closes the idle channels; channels still checked out are closed
+// when they are put back
+func (p *ChannelPool) Close() error {
+	return p.pool.close()
+}
+
+var (
+	connPoolsMu sync.Mutex
+	connPools   = make(map[*amqp.Connection]*ChannelPool)
+)
+
+// PoolFor returns the ChannelPool shared by everything publishing on
+// conn, creating it on first use. The helpers that take a connection
+// publish through it; the pool is closed along with conn.
+func PoolFor(conn *amqp.Connection) *ChannelPool {
+	connPoolsMu.Lock()
+	defer connPoolsMu.Unlock()
+	if p, ok := connPools[conn]; ok {
+		return p
+	}
+	p := NewChannelPool(conn, 0)
+	connPools[conn] = p
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		<-closed
+		connPoolsMu.Lock()
+		delete(connPools, conn)
+		connPoolsMu.Unlock()
+		p.Close()
+	}()
+	return p
+}
+
+// pooledChannel is a channel a channelPool hands out, an *amqp.Channel
+// outside of tests
+type pooledChannel interface {
+	PublishChannel
+	IsClosed() bool
+	Close() error
+}
+
+// channelPool implements ChannelPool for any kind of channel, so tests
+// can pool fakes
+type channelPool[C pooledChannel] struct {
+	open  func() (C, error)
+	idle  chan C
+	slots chan struct{} // one token per open channel
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newChannelPool[C pooledChannel](open func() (C, error), maxChannels int) channelPool[C] {
+	if maxChannels <= 0 {
+		maxChannels = 16
+	}
+	return channelPool[C]{
+		open:  open,
+		idle:  make(chan C, maxChannels),
+		slots: make(chan struct{}, maxChannels),
+	}
+}
+
+func (p *channelPool[C]) get(ctx context.Context) (C, error) {
+	var none C
+	for {
+		if p.isClosed() {
+			return none, ErrPoolClosed
+		}
+		// prefer an idle channel: select alone picks at random and would
+		// open channels up to the cap even while idle ones are waiting
+		select {
+		case ch := <-p.idle:
+			if !ch.IsClosed() {
+				return ch, nil
+			}
+			<-p.slots // replace it
+			continue
+		default:
+		}
+		select {
+		case ch := <-p.idle:
+			if !ch.IsClosed() {
+				return ch, nil
+			}
+			<-p.slots // replace it
+		case p.slots <- struct{}{}:
+			// the pool may have closed while we waited for the slot, and
+			// nothing would ever close a channel opened now
+			if p.isClosed() {
+				<-p.slots
+				return none, ErrPoolClosed
+			}
+			ch, err := p.open()
+			if err != nil {
+				<-p.slots
+				return none, fmt.Errorf("failed to open channel: %w", err)
+			}
+			return ch, nil
+		case <-ctx.Done():
+			return none, ctx.Err()
+		}
+	}
+}
+
+func (p *channelPool[C]) put(ch C) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ch.IsClosed() || p.closed {
+		ch.Close()
+		<-p.slots
+		return
+	}
+	p.idle <- ch // never blocks: idle has room for every slot
+}
+
+func (p *channelPool[C]) publish(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	ch, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+	defer p.put(ch)
+	return ch.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+func (p *channelPool[C]) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+
+	for {
+		select {
+		case ch := <-p.idle:
+			ch.Close()
+			<-p.slots
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *channelPool[C]) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
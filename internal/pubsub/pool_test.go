@@ -0,0 +1,263 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type fakePoolChannel struct {
+	mu        sync.Mutex
+	closed    bool
+	published int
+}
+
+func (c *fakePoolChannel) PublishWithContext(context.Context, string, string, bool, bool, amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	c.published++
+	return nil
+}
+
+func (c *fakePoolChannel) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *fakePoolChannel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// fakePoolConn opens fakePoolChannels, failing while err is set
+type fakePoolConn struct {
+	mu       sync.Mutex
+	err      error
+	channels []*fakePoolChannel
+}
+
+func (c *fakePoolConn) open() (*fakePoolChannel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	ch := &fakePoolChannel{}
+	c.channels = append(c.channels, ch)
+	return ch, nil
+}
+
+func (c *fakePoolConn) opened() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.channels)
+}
+
+func TestChannelPoolGet(t *testing.T) {
+	errRefused := errors.New("channel_max reached")
+	tests := []struct {
+		name       string
+		run        func(t *testing.T, p *channelPool[*fakePoolChannel], conn *fakePoolConn)
+		wantOpened int
+	}{
+		{
+			name: "reuses idle channels",
+			run: func(t *testing.T, p *channelPool[*fakePoolChannel], _ *fakePoolConn) {
+				for i := 0; i < 5; i++ {
+					if err := p.publish(context.Background(), "x", "k", false, false, amqp.Publishing{}); err != nil {
+						t.Fatalf("publish: %v", err)
+					}
+				}
+			},
+			wantOpened: 1,
+		},
+		{
+			name: "replaces a channel closed while checked out",
+			run: func(t *testing.T, p *channelPool[*fakePoolChannel], _ *fakePoolConn) {
+				ch, err := p.get(context.Background())
+				if err != nil {
+					t.Fatalf("get: %v", err)
+				}
+				ch.Close()
+				p.put(ch)
+				if ch, err = p.get(context.Background()); err != nil || ch.IsClosed() {
+					t.Fatalf("get after a closed put = %v, %v", ch, err)
+				}
+			},
+			wantOpened: 2,
+		},
+		{
+			name: "replaces a channel closed while idle",
+			run: func(t *testing.T, p *channelPool[*fakePoolChannel], _ *fakePoolConn) {
+				ch, _ := p.get(context.Background())
+				p.put(ch)
+				ch.Close() // e.g. by the broker
+				if ch, err := p.get(context.Background()); err != nil || ch.IsClosed() {
+					t.Fatalf("get = %v, %v", ch, err)
+				}
+			},
+			wantOpened: 2,
+		},
+		{
+			name: "open error frees the slot",
+			run: func(t *testing.T, p *channelPool[*fakePoolChannel], conn *fakePoolConn) {
+				conn.err = errRefused
+				for i := 0; i < 3; i++ {
+					if _, err := p.get(context.Background()); !errors.Is(err, errRefused) {
+						t.Fatalf("get: err = %v, want %v", err, errRefused)
+					}
+				}
+				conn.err = nil
+				if _, err := p.get(context.Background()); err != nil {
+					t.Fatalf("get after the error cleared: %v", err)
+				}
+			},
+			wantOpened: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakePoolConn{}
+			p := newChannelPool(conn.open, 2)
+			tt.run(t, &p, conn)
+			if n := conn.opened(); n != tt.wantOpened {
+				t.Errorf("opened %d channels, want %d", n, tt.wantOpened)
+			}
+		})
+	}
+}
+
+func TestChannelPoolCapsChannels(t *testing.T) {
+	conn := &fakePoolConn{}
+	p := newChannelPool(conn.open, 3)
+
+	var checkedOut []*fakePoolChannel
+	for i := 0; i < 3; i++ {
+		ch, err := p.get(context.Background())
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		checkedOut = append(checkedOut, ch)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("get over the cap: err = %v, want deadline exceeded", err)
+	}
+
+	got := make(chan *fakePoolChannel)
+	go func() {
+		ch, _ := p.get(context.Background())
+		got <- ch
+	}()
+	p.put(checkedOut[1])
+	select {
+	case ch := <-got:
+		if ch != checkedOut[1] {
+			t.Error("a waiting get did not receive the returned channel")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a waiting get was not woken by put")
+	}
+	if n := conn.opened(); n != 3 {
+		t.Errorf("opened %d channels, want 3", n)
+	}
+}
+
+func TestChannelPoolConcurrentPublish(t *testing.T) {
+	conn := &fakePoolConn{}
+	p := newChannelPool(conn.open, 4)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := p.publish(context.Background(), "x", "k", false, false, amqp.Publishing{}); err != nil {
+					t.Errorf("publish: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := conn.opened(); n > 4 {
+		t.Errorf("opened %d channels, cap is 4", n)
+	}
+	total := 0
+	for _, ch := range conn.channels {
+		total += ch.published
+	}
+	if total != 1000 {
+		t.Errorf("published %d messages, want 1000", total)
+	}
+}
+
+func TestChannelPoolClose(t *testing.T) {
+	conn := &fakePoolConn{}
+	p := newChannelPool(conn.open, 2)
+
+	idle, _ := p.get(context.Background())
+	busy, _ := p.get(context.Background())
+	p.put(idle)
+
+	if err := p.close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if !idle.IsClosed() {
+		t.Error("close left an idle channel open")
+	}
+	if busy.IsClosed() {
+		t.Error("close closed a checked-out channel")
+	}
+	p.put(busy)
+	if !busy.IsClosed() {
+		t.Error("a channel put back after close stayed open")
+	}
+	if _, err := p.get(context.Background()); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("get after close: err = %v, want %v", err, ErrPoolClosed)
+	}
+	if err := p.publish(context.Background(), "x", "k", false, false, amqp.Publishing{}); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("publish after close: err = %v, want %v", err, ErrPoolClosed)
+	}
+}
+
+func TestChannelPoolCloseWakesWaiters(t *testing.T) {
+	conn := &fakePoolConn{}
+	p := newChannelPool(conn.open, 1)
+	busy, _ := p.get(context.Background())
+
+	// a get waiting at the cap when the pool closes must not open a
+	// channel once put frees the slot: nothing would ever close it
+	done := make(chan error)
+	go func() {
+		_, err := p.get(context.Background())
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	p.close()
+	p.put(busy)
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrPoolClosed) {
+			t.Errorf("waiting get: err = %v, want %v", err, ErrPoolClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the waiting get never returned")
+	}
+	if n := conn.opened(); n != 1 {
+		t.Errorf("opened %d channels, want 1", n)
+	}
+}
@@ -0,0 +1,44 @@
+package pubsub
+
+import (
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// PublishOption adjusts a message before the publish helpers send it
+type PublishOption func(*amqp.Publishing)
+
+// WithPriority sets an explicit message priority, overriding the one
+// derived from the routing key or the payload
+func WithPriority(priority uint8) PublishOption {
+	return func(msg *amqp.Publishing) {
+		msg.Priority = priority
+	}
+}
+
+// Prioritized can be implemented by a payload to choose its own priority,
+// e.g. from an "urgent" field on an order
+type Prioritized interface {
+	Priority() uint8
+}
+
+// newPublishing builds the message sent by the publish helpers. Its
+// priority comes from the routing key (see routing.PriorityForKey), then
+// from the payload if it implements Prioritized, then from opts.
+func newPublishing(contentType, key string, body []byte, val any, opts []PublishOption) amqp.Publishing {
+	msg := amqp.Publishing{
+		ContentType: contentType,
+		Timestamp:   time.Now().UTC(),
+		Priority:    routing.PriorityForKey(key),
+		Body:        body,
+	}
+	if p, ok := val.(Prioritized); ok {
+		msg.Priority = p.Priority()
+	}
+	for _, opt := range opts {
+		opt(&msg)
+	}
+	return msg
+}
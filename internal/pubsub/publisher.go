@@ -0,0 +1,302 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrPublisherClosed is returned when publishing on a closed Publisher
+var ErrPublisherClosed = errors.New("publisher closed")
+
+// ErrNacked is the Future error when the broker refused a message
+var ErrNacked = errors.New("message nacked by broker")
+
+// PublisherConfig sizes a Publisher. Zero values use the defaults.
+type PublisherConfig struct {
+	Channels    int // confirm-mode channels publishing in parallel (default 4)
+	MaxInFlight int // unconfirmed messages before Publish blocks (default 1000)
+	BufferSize  int // messages queued for a channel (default MaxInFlight)
+}
+
+// Publisher publishes asynchronously for high throughput: messages are
+// buffered, pipelined over a pool of confirm-mode channels without
+// waiting for each confirm, and matched to their confirm by sequence
+// number. Every message gets a Future. Publish blocks once MaxInFlight
+// messages are unconfirmed, pushing back on producers.
+type Publisher struct {
+	open     func() (confirmChannel, error)
+	queue    chan *publishRequest
+	slots    chan struct{} // one token per in-flight message
+	inflight sync.WaitGroup
+	workers  sync.WaitGroup
+
+	mu     sync.RWMutex // guards closed and sending on queue
+	closed bool
+}
+
+type publishRequest struct {
+	ctx      context.Context
+	exchange string
+	key      string
+	msg      amqp.Publishing
+	future   *Future
+	start    time.Time
+	span     *tracing.Span
+}
+
+// Future is the outcome of an asynchronous publish
+type Future struct {
+	done chan struct{}
+	err  error
+	once sync.Once
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+func (f *Future) resolve(err error) {
+	f.once.Do(func() {
+		f.err = err
+		close(f.done)
+	})
+}
+
+// Done is closed once the message was confirmed or failed
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Err returns the publish error once Done is closed: nil when the broker
+// confirmed the message, ErrNacked when it refused it, or the channel error
+func (f *Future) Err() error {
+	<-f.done
+	return f.err
+}
+
+// Wait blocks until the message is confirmed or ctx is done
+func (f *Future) Wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NewPublisher opens cfg.Channels confirm-mode channels on conn
+func NewPublisher(conn *amqp.Connection, cfg PublisherConfig) (*Publisher, error) {
+	open := func() (confirmChannel, error) {
+		return conn.Channel()
+	}
+	return newPublisher(open, cfg)
+}
+
+func newPublisher(open func() (confirmChannel, error), cfg PublisherConfig) (*Publisher, error) {
+	if cfg.Channels <= 0 {
+		cfg.Channels = 4
+	}
+	if cfg.MaxInFlight <= 0 {
+		cfg.MaxInFlight = 1000
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = cfg.MaxInFlight
+	}
+
+	p := &Publisher{
+		open:  open,
+		queue: make(chan *publishRequest, cfg.BufferSize),
+		slots: make(chan struct{}, cfg.MaxInFlight),
+	}
+	for i := 0; i < cfg.Channels; i++ {
+		w := &confirmWorker{p: p}
+		if err := w.open(); err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.workers.Add(1)
+		go w.run()
+	}
+	return p, nil
+}
+
+// Publish queues msg and returns its Future. It blocks while MaxInFlight
+// messages are unconfirmed, until ctx is done.
+func (p *Publisher) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) (*Future, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		<-p.slots
+		return nil, ErrPublisherClosed
+	}
+
+	req := &publishRequest{ctx: ctx, exchange: exchange, key: key, msg: msg, future: newFuture(), start: time.Now()}
+	// the span lasts until the confirm
+	_, req.span = startPublishSpan(ctx, exchange, key, &req.msg)
+	p.inflight.Add(1)
+	p.queue <- req
+	return req.future, nil
+}
+
+// PublishAsync encodes val as JSON and publishes it through p
+func PublishAsync[T any](ctx context.Context, p *Publisher, exchange, key string, val T, opts ...PublishOption) (*Future, error) {
+	body, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	return p.Publish(ctx, exchange, key, newPublishing("application/json", key, body, val, opts))
+}
+
+// InFlight returns how many messages are queued or awaiting a confirm
+func (p *Publisher) InFlight() int {
+	return len(p.slots)
+}
+
+// Close stops accepting messages, waits for the outstanding confirms and
+// closes the channels
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.queue)
+	p.mu.Unlock()
+
+	p.inflight.Wait()
+	p.workers.Wait()
+	return nil
+}
+
+// done resolves a request and frees its in-flight slot
+func (p *Publisher) done(req *publishRequest, err error) {
+	observePublish(req.exchange, req.start, err)
+	req.span.SetError(err)
+	req.span.End()
+	req.future.resolve(err)
+	<-p.slots
+	p.inflight.Done()
+}
+
+// confirmChannel is the channel a confirmWorker publishes on, an
+// *amqp.Channel outside of tests
+type confirmChannel interface {
+	PublishChannel
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	GetNextPublishSeqNo() uint64
+	IsClosed() bool
+	Close() error
+}
+
+// confirmWorker owns one confirm-mode channel
+type confirmWorker struct {
+	p  *Publisher
+	ch confirmChannel
+
+	mu      sync.Mutex
+	pending map[uint64]*publishRequest // by publish sequence number
+}
+
+func (w *confirmWorker) open() error {
+	ch, err := w.p.open()
+	if err != nil {
+		return fmt.Errorf("failed to open channel: %w", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return fmt.Errorf("failed to enable confirms: %w", err)
+	}
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, cap(w.p.slots)))
+
+	w.mu.Lock()
+	w.ch = ch
+	w.pending = make(map[uint64]*publishRequest)
+	pending := w.pending
+	w.mu.Unlock()
+
+	go w.listen(confirms, pending)
+	return nil
+}
+
+// listen resolves futures as confirms arrive. The confirm channel closes
+// with the amqp channel; whatever is still pending then has failed.
+func (w *confirmWorker) listen(confirms <-chan amqp.Confirmation, pending map[uint64]*publishRequest) {
+	for c := range confirms {
+		w.mu.Lock()
+		req, ok := pending[c.DeliveryTag]
+		delete(pending, c.DeliveryTag)
+		w.mu.Unlock()
+		if !ok {
+			continue
+		}
+		if c.Ack {
+			w.p.done(req, nil)
+		} else {
+			confirmFailures.With(req.exchange).Inc()
+			w.p.done(req, ErrNacked)
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for tag, req := range pending {
+		delete(pending, tag)
+		confirmFailures.With(req.exchange).Inc()
+		w.p.done(req, amqp.ErrClosed)
+	}
+}
+
+func (w *confirmWorker) run() {
+	defer w.p.workers.Done()
+	defer func() {
+		w.mu.Lock()
+		ch := w.ch
+		w.mu.Unlock()
+		// wait for the last confirms before closing the channel
+		w.p.inflight.Wait()
+		ch.Close()
+	}()
+
+	for req := range w.p.queue {
+		if w.ch.IsClosed() {
+			if err := w.open(); err != nil {
+				w.p.done(req, err)
+				continue
+			}
+			channelReopens.With("publisher").Inc()
+		}
+		w.publish(req)
+	}
+}
+
+func (w *confirmWorker) publish(req *publishRequest) {
+	w.mu.Lock()
+	seq := w.ch.GetNextPublishSeqNo()
+	w.pending[seq] = req
+	w.mu.Unlock()
+
+	if err := w.ch.PublishWithContext(req.ctx, req.exchange, req.key, false, false, req.msg); err != nil {
+		w.mu.Lock()
+		_, stillPending := w.pending[seq]
+		delete(w.pending, seq)
+		w.mu.Unlock()
+		if stillPending {
+			w.p.done(req, err)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeConfirmChannel numbers publishes like a confirm-mode channel and
+// confirms them according to confirm
+type fakeConfirmChannel struct {
+	// confirm decides how the broker answers a publish: ack or nack, or
+	// no confirm at all when send is false
+	confirm    func(msg amqp.Publishing) (ack, send bool)
+	publishErr error
+
+	mu        sync.Mutex
+	seq       uint64
+	confirms  chan amqp.Confirmation
+	closed    bool
+	published []amqp.Publishing
+}
+
+func (c *fakeConfirmChannel) Confirm(bool) error { return nil }
+
+func (c *fakeConfirmChannel) NotifyPublish(confirms chan amqp.Confirmation) chan amqp.Confirmation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.confirms = confirms
+	return confirms
+}
+
+func (c *fakeConfirmChannel) GetNextPublishSeqNo() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seq + 1
+}
+
+func (c *fakeConfirmChannel) PublishWithContext(_ context.Context, _, _ string, _, _ bool, msg amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	if c.publishErr != nil {
+		return c.publishErr
+	}
+	c.seq++
+	c.published = append(c.published, msg)
+	if ack, send := c.confirm(msg); send {
+		c.confirms <- amqp.Confirmation{DeliveryTag: c.seq, Ack: ack}
+	}
+	return nil
+}
+
+func (c *fakeConfirmChannel) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *fakeConfirmChannel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.confirms)
+	}
+	return nil
+}
+
+// fakeConnection opens fakeConfirmChannels and remembers them
+type fakeConnection struct {
+	confirm    func(msg amqp.Publishing) (ack, send bool)
+	publishErr error
+
+	mu       sync.Mutex
+	channels []*fakeConfirmChannel
+}
+
+func (c *fakeConnection) open() (confirmChannel, error) {
+	c.mu.Lock()
+	ch := &fakeConfirmChannel{confirm: c.confirm, publishErr: c.publishErr}
+	c.channels = append(c.channels, ch)
+	c.mu.Unlock()
+	return ch, nil
+}
+
+func (c *fakeConnection) opened() []*fakeConfirmChannel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*fakeConfirmChannel(nil), c.channels...)
+}
+
+func ackAll(amqp.Publishing) (bool, bool)  { return true, true }
+func nackAll(amqp.Publishing) (bool, bool) { return false, true }
+func never(amqp.Publishing) (bool, bool)   { return false, false }
+
+func TestPublisherFutures(t *testing.T) {
+	errPublish := errors.New("connection blocked")
+	tests := []struct {
+		name       string
+		confirm    func(amqp.Publishing) (bool, bool)
+		publishErr error
+		want       error
+	}{
+		{"acked", ackAll, nil, nil},
+		{"nacked", nackAll, nil, ErrNacked},
+		{"publish error", ackAll, errPublish, errPublish},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConnection{confirm: tt.confirm, publishErr: tt.publishErr}
+			p, err := newPublisher(conn.open, PublisherConfig{Channels: 3, MaxInFlight: 10})
+			if err != nil {
+				t.Fatalf("newPublisher: %v", err)
+			}
+			if n := len(conn.opened()); n != 3 {
+				t.Errorf("opened %d channels, want 3", n)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			var futures []*Future
+			for i := 0; i < 50; i++ {
+				f, err := p.Publish(ctx, "x", "k", amqp.Publishing{Body: []byte("m")})
+				if err != nil {
+					t.Fatalf("Publish: %v", err)
+				}
+				futures = append(futures, f)
+			}
+			for i, f := range futures {
+				if err := f.Wait(ctx); !errors.Is(err, tt.want) {
+					t.Fatalf("future %d: err = %v, want %v", i, err, tt.want)
+				}
+			}
+			if err := p.Close(); err != nil {
+				t.Errorf("Close: %v", err)
+			}
+			if n := p.InFlight(); n != 0 {
+				t.Errorf("InFlight after Close = %d", n)
+			}
+			for i, ch := range conn.opened() {
+				if !ch.IsClosed() {
+					t.Errorf("channel %d still open after Close", i)
+				}
+			}
+		})
+	}
+}
+
+func TestPublisherBlocksAtMaxInFlight(t *testing.T) {
+	conn := &fakeConnection{confirm: never}
+	p, err := newPublisher(conn.open, PublisherConfig{Channels: 1, MaxInFlight: 2})
+	if err != nil {
+		t.Fatalf("newPublisher: %v", err)
+	}
+
+	var futures []*Future
+	for i := 0; i < 2; i++ {
+		f, err := p.Publish(context.Background(), "x", "k", amqp.Publishing{})
+		if err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+		futures = append(futures, f)
+	}
+	if n := p.InFlight(); n != 2 {
+		t.Errorf("InFlight = %d, want 2", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.Publish(ctx, "x", "k", amqp.Publishing{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Publish over MaxInFlight: err = %v, want deadline exceeded", err)
+	}
+
+	// losing the channel fails the unconfirmed messages and frees their slots
+	waitFor(t, "the publishes", func() bool {
+		chs := conn.opened()
+		chs[0].mu.Lock()
+		defer chs[0].mu.Unlock()
+		return len(chs[0].published) == 2
+	})
+	conn.opened()[0].Close()
+	for i, f := range futures {
+		if err := f.Err(); !errors.Is(err, amqp.ErrClosed) {
+			t.Errorf("future %d: err = %v, want %v", i, err, amqp.ErrClosed)
+		}
+	}
+	if n := p.InFlight(); n != 0 {
+		t.Errorf("InFlight = %d, want 0", n)
+	}
+
+	// the next message goes out on a fresh channel
+	conn.mu.Lock()
+	conn.confirm = ackAll
+	conn.mu.Unlock()
+	f, err := p.Publish(context.Background(), "x", "k", amqp.Publishing{})
+	if err != nil {
+		t.Fatalf("Publish after channel loss: %v", err)
+	}
+	if err := f.Err(); err != nil {
+		t.Errorf("publish on the reopened channel: %v", err)
+	}
+	if n := len(conn.opened()); n != 2 {
+		t.Errorf("opened %d channels, want 2", n)
+	}
+	p.Close()
+}
+
+func TestPublisherClose(t *testing.T) {
+	conn := &fakeConnection{confirm: ackAll}
+	p, err := newPublisher(conn.open, PublisherConfig{Channels: 2})
+	if err != nil {
+		t.Fatalf("newPublisher: %v", err)
+	}
+	f, err := PublishAsync(context.Background(), p, "x", "k", struct{ ID string }{"ORD-1"})
+	if err != nil {
+		t.Fatalf("PublishAsync: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case <-f.Done():
+	default:
+		t.Error("Close returned before the outstanding confirm")
+	}
+	if _, err := p.Publish(context.Background(), "x", "k", amqp.Publishing{}); !errors.Is(err, ErrPublisherClosed) {
+		t.Errorf("Publish after Close: err = %v, want %v", err, ErrPublisherClosed)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
@@ -5,10 +5,13 @@ import (
 	"context"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
 	"time"
 
+	"github.com/abdooman21/ecom-plat/internal/tracing"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -17,6 +20,8 @@ type SimpleQueueType int
 const (
 	Durable = iota
 	Transient
+	Quorum // replicated queue, durable by definition
+	Stream // append-only log consumed by offset, see WithStreamOffset
 )
 
 type AckType int
@@ -30,22 +35,38 @@ const (
 func Connect_RabbitMQ(url string) (conn *amqp.Connection) {
 	conn, err := amqp.Dial(url)
 	if err != nil {
-		fmt.Printf("failed at connection, ")
+		logger().Error("failed to connect to RabbitMQ", "error", err)
 		// exit
-		log.Fatal(err)
+		os.Exit(1)
 	}
-	log.Println("Connected to RabbitMQ successfully")
+	logger().Info("connected to RabbitMQ")
+	connections.With().Inc()
+	go watchConnection(conn)
 	return
 
 }
 
+// watchConnection counts connections lost to errors; a clean Close
+// reports no error
+func watchConnection(conn *amqp.Connection) {
+	if err := <-conn.NotifyClose(make(chan *amqp.Error, 1)); err != nil {
+		logger().Error("connection to RabbitMQ lost", "error", err)
+		connectionsLost.With().Inc()
+	}
+}
+
 func DeclareAndBind(
 	conn *amqp.Connection,
 	exchange,
 	queueName,
 	key string,
-	queueType SimpleQueueType, // an enum to represent "durable" or "transient"
+	queueType SimpleQueueType, // an enum to represent "durable", "transient", "quorum" or "stream"
+	opts ...Option,
 ) (*amqp.Channel, amqp.Queue, error) {
+	table, err := queueArgs(queueType, newOptions(opts))
+	if err != nil {
+		return nil, amqp.Queue{}, fmt.Errorf("invalid queue options: %w", err)
+	}
 
 	ch, err := conn.Channel()
 
@@ -53,8 +74,7 @@ func DeclareAndBind(
 		return nil, amqp.Queue{}, fmt.Errorf("failed to open channel: %w", err)
 	}
 	transient := queueType == Transient
-	durable := queueType == Durable
-	table := amqp.Table{"x-dead-letter-exchange": "peril_dlx"}
+	durable := !transient
 	qu, err := ch.QueueDeclare(queueName, durable, transient, transient, false, table)
 
 	if err != nil {
@@ -75,101 +95,234 @@ func Subscribe[T any](
 	QueueType SimpleQueueType,
 	handler func(*T) AckType,
 	unmarshaller func([]byte) (*T, error),
+	opts ...Option,
+) error {
+	_, err := startConsumer(conn, exchange, queueName, key, QueueType, withoutContext(handler), bodyDecoder(unmarshaller), opts...)
+	return err
+}
+
+// SubscribeContext is Subscribe for handlers that take a context. The
+// context carries the consumer span, so messages the handler publishes
+// with it (e.g. through PubJSONwithCTX) join the trace of the message
+// being handled.
+func SubscribeContext[T any](
+	conn *amqp.Connection,
+	exchange,
+	queueName,
+	key string,
+	QueueType SimpleQueueType,
+	handler func(context.Context, *T) AckType,
+	unmarshaller func([]byte) (*T, error),
+	opts ...Option,
 ) error {
-	ch, _, err := DeclareAndBind(conn, exchange, queueName, key, QueueType)
+	_, err := startConsumer(conn, exchange, queueName, key, QueueType, handler, bodyDecoder(unmarshaller), opts...)
+	return err
+}
+
+// SubscribeRaw is SubscribeContext for handlers that need the whole
+// delivery, e.g. its routing key and headers, rather than a decoded body.
+// The handler must not settle the delivery itself; its AckType does that.
+func SubscribeRaw(
+	conn *amqp.Connection,
+	exchange,
+	queueName,
+	key string,
+	QueueType SimpleQueueType,
+	handler func(context.Context, *amqp.Delivery) AckType,
+	opts ...Option,
+) error {
+	raw := func(d amqp.Delivery) (*amqp.Delivery, error) { return &d, nil }
+	_, err := startConsumer(conn, exchange, queueName, key, QueueType, handler, raw, opts...)
+	return err
+}
+
+// withoutContext adapts a handler that takes no context to startConsumer
+func withoutContext[T any](handler func(*T) AckType) func(context.Context, *T) AckType {
+	return func(_ context.Context, msg *T) AckType { return handler(msg) }
+}
+
+// bodyDecoder adapts an unmarshaller to the decode step of startConsumer
+func bodyDecoder[T any](unmarshaller func([]byte) (*T, error)) func(amqp.Delivery) (*T, error) {
+	return func(d amqp.Delivery) (*T, error) { return unmarshaller(d.Body) }
+}
+
+// consumer is a running Subscribe loop that can be stopped
+type consumer struct {
+	ch   consumerChannel
+	tag  string
+	done chan struct{} // closed once every delivery has been handled
+}
+
+// consumerChannel is the channel a consumer runs on, an *amqp.Channel
+// outside of tests
+type consumerChannel interface {
+	Cancel(consumer string, noWait bool) error
+	IsClosed() bool
+	Close() error
+}
+
+// stop cancels the consumer, waits for the deliveries already received
+// to be handled and closes the channel
+func (c *consumer) stop() {
+	if err := c.ch.Cancel(c.tag, false); err == nil {
+		<-c.done
+	}
+	c.ch.Close()
+}
+
+// deliveryHandler decodes, deduplicates, handles and settles one delivery
+func deliveryHandler[T any](
+	queueName string,
+	o *options,
+	handler func(context.Context, *T) AckType,
+	decode func(amqp.Delivery) (*T, error),
+) func(amqp.Delivery) {
+	lg := o.logger.With(slog.String("queue", queueName))
+	consumed := messagesConsumed.With(queueName)
+	latency := handlerDuration.With(queueName)
+	return func(d amqp.Delivery) {
+		consumed.Inc()
+		ctx, span := startConsumeSpan(context.Background(), d, queueName)
+		defer span.End()
+		dedup := o.idempotency != nil && d.MessageId != ""
+		if dedup && !claim(lg, o.idempotency, d.MessageId) {
+			d.Ack(false)
+			messagesSettled.With(queueName, "duplicate").Inc()
+			span.SetAttributes(tracing.String("messaging.rabbitmq.outcome", "duplicate"))
+			return
+		}
+		msg, err := decode(d)
+		if err != nil {
+			lg.Warn("failed to decode message, discarding", append(deliveryAttrs(d), "error", err)...)
+			if dedup {
+				// undecodable messages are not recorded: drop the claim
+				release(lg, o.idempotency, d.MessageId)
+			}
+			d.Nack(false, false) // discard
+			messagesSettled.With(queueName, "decode_error").Inc()
+			span.SetError(fmt.Errorf("failed to decode message: %w", err))
+			return
+		}
+		waitLimiters(o.limiters, 1)
+		start := time.Now()
+		ack := handler(ctx, msg)
+		latency.Observe(time.Since(start).Seconds())
+		messagesSettled.With(queueName, ack.String()).Inc()
+		span.SetAttributes(tracing.String("messaging.rabbitmq.outcome", ack.String()))
+		if ack == Discard {
+			span.SetError(errors.New("message discarded by handler"))
+		}
+		if dedup {
+			settle(lg, o.idempotency, d.MessageId, ack)
+		}
+		switch ack {
+		case Ack:
+			d.Ack(false)
+		case Requeue:
+			d.Nack(false, true)
+		case Discard:
+			d.Nack(false, false)
+		}
+		logSettled(lg, d, ack)
+	}
+}
+
+func startConsumer[T any](
+	conn *amqp.Connection,
+	exchange,
+	queueName,
+	key string,
+	QueueType SimpleQueueType,
+	handler func(context.Context, *T) AckType,
+	decode func(amqp.Delivery) (*T, error),
+	opts ...Option,
+) (*consumer, error) {
+	ch, _, err := DeclareAndBind(conn, exchange, queueName, key, QueueType, opts...)
 	if err != nil {
-		return fmt.Errorf("at declaring and binding: %w", err)
+		return nil, fmt.Errorf("at declaring and binding: %w", err)
 	}
-	err = ch.Qos(10, 0, false)
+	o := newOptions(opts)
+	err = ch.Qos(max(o.prefetch, o.partitions), 0, false)
 	if err != nil {
-		return fmt.Errorf("failed prefetch limit: %w", err)
+		return nil, fmt.Errorf("failed prefetch limit: %w", err)
 	}
-	msgs, err := ch.Consume(queueName, "", false, false, false, false, nil)
+	args := consumeArgs(QueueType, o)
+	tag := consumerTag(queueName)
+	msgs, err := ch.Consume(queueName, tag, false, false, false, false, args)
 	if err != nil {
-		return fmt.Errorf("failed to register consumer: %w", err)
+		return nil, fmt.Errorf("failed to register consumer: %w", err)
+	}
+	handle := deliveryHandler(queueName, o, handler, decode)
+
+	c := &consumer{ch: ch, tag: tag, done: make(chan struct{})}
+	if o.partitions > 1 {
+		go func() {
+			dispatchPartitioned(msgs, o, handle)
+			close(c.done)
+		}()
+		return c, nil
 	}
 	go func() {
 		for d := range msgs {
-			msg, err := unmarshaller(d.Body)
-			if err != nil {
-				log.Printf("failed to decode message: %v", err)
-				d.Nack(false, false) // discard
-				continue
-			}
-			ack := handler(msg)
-			switch ack {
-			case Ack:
-				d.Ack(false)
-				log.Println(" Hancdler Ack meesage ")
-			case Requeue:
-				d.Nack(false, true)
-				log.Println(" Hancdler requeue meesage ")
-			case Discard:
-				d.Nack(false, false)
-				log.Println(" Hancdler discard meesage ")
-			}
+			handle(d)
 		}
+		close(c.done)
 	}()
 
-	return nil
+	return c, nil
 }
 
-func PubGob[T any](ch *amqp.Channel, exchange, key string, val T) error {
+// consumerTag builds a unique consumer tag that still tells which queue
+// the consumer reads, which helps in the management UI
+func consumerTag(queueName string) string {
+	return queueName + "-" + newCorrelationID()[:12]
+}
+
+func PubGob[T any](ch PublishChannel, exchange, key string, val T, opts ...PublishOption) error {
 	var buf bytes.Buffer
 	if err := gob.NewEncoder(&buf).Encode(val); err != nil {
 		return err
 	}
-	return ch.PublishWithContext(context.Background(),
-		exchange,
-		key,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "application/gob",
-			Timestamp:   time.Now().UTC(),
-			Body:        buf.Bytes(),
-		},
-	)
+	return publish(context.Background(), ch, exchange, key, newPublishing("application/gob", key, buf.Bytes(), val, opts))
 
 }
 
-func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
+func PublishJSON[T any](ch PublishChannel, exchange, key string, val T, opts ...PublishOption) error {
 
 	body, err := json.Marshal(val)
 	if err != nil {
 		return err
 	}
 
-	return ch.PublishWithContext(context.Background(),
-		exchange,
-		key,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Timestamp:   time.Now().UTC(),
-			Body:        body,
-		},
-	)
-}
-func PubJSONwithCTX[T any](ctx context.Context, ch *amqp.Channel, exchange, key string, val T) error {
+	return publish(context.Background(), ch, exchange, key, newPublishing("application/json", key, body, val, opts))
+}
+func PubJSONwithCTX[T any](ctx context.Context, ch PublishChannel, exchange, key string, val T, opts ...PublishOption) error {
 	body, err := json.Marshal(val)
 	if err != nil {
 		return err
 	}
-	return ch.PublishWithContext(ctx,
-		exchange,
-		key,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Timestamp:   time.Now().UTC(),
-			Body:        body,
-		},
-	)
+	return publish(ctx, ch, exchange, key, newPublishing("application/json", key, body, val, opts))
+
+}
 
+// PublishRaw sends a message that is already encoded, e.g. one recorded
+// earlier, with the same tracing and metrics as the other helpers. The
+// message is sent as is: no priority or timestamp is filled in.
+func PublishRaw(ctx context.Context, ch PublishChannel, exchange, key string, msg amqp.Publishing) error {
+	return publish(ctx, ch, exchange, key, msg)
 }
+
+// publish sends msg for the publish helpers and records its metrics
+func publish(ctx context.Context, ch PublishChannel, exchange, key string, msg amqp.Publishing) error {
+	start := time.Now()
+	ctx, span := startPublishSpan(ctx, exchange, key, &msg)
+	err := ch.PublishWithContext(ctx, exchange, key, false, false, msg)
+	observePublish(exchange, start, err)
+	span.SetError(err)
+	span.End()
+	return err
+}
+
 func JSONUnmarshaller[T any](body []byte) (*T, error) {
 	var msg T
 	err := json.Unmarshal(body, &msg)
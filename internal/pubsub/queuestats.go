@@ -0,0 +1,158 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/management"
+	"github.com/abdooman21/ecom-plat/internal/metrics"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// QueueStats is a snapshot of a queue's backlog
+type QueueStats struct {
+	Ready     int       // messages waiting to be delivered
+	Unacked   int       // messages delivered but not settled yet
+	Consumers int       // consumers attached to the queue
+	Oldest    time.Time // timestamp of the oldest message, zero when unknown
+}
+
+// QueueStatsSource reads the stats of a queue from the broker
+type QueueStatsSource interface {
+	QueueStats(ctx context.Context, queue string) (QueueStats, error)
+}
+
+// PassiveStats reads queue stats with a passive QueueDeclare. It needs no
+// management plugin, but only knows the ready messages and consumers.
+type PassiveStats struct {
+	open func() (passiveChannel, error)
+
+	mu sync.Mutex
+	ch passiveChannel
+}
+
+// passiveChannel is the channel PassiveStats declares on, an
+// *amqp.Channel outside of tests
+type passiveChannel interface {
+	QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	IsClosed() bool
+}
+
+func NewPassiveStats(conn *amqp.Connection) *PassiveStats {
+	return newPassiveStats(func() (passiveChannel, error) {
+		return conn.Channel()
+	})
+}
+
+func newPassiveStats(open func() (passiveChannel, error)) *PassiveStats {
+	return &PassiveStats{open: open}
+}
+
+func (p *PassiveStats) QueueStats(_ context.Context, queue string) (QueueStats, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// declaring a missing queue closes the channel, so reopen it as needed
+	if p.ch == nil || p.ch.IsClosed() {
+		ch, err := p.open()
+		if err != nil {
+			return QueueStats{}, fmt.Errorf("failed to open channel: %w", err)
+		}
+		p.ch = ch
+	}
+	q, err := p.ch.QueueDeclarePassive(queue, true, false, false, false, nil)
+	if err != nil {
+		return QueueStats{}, fmt.Errorf("failed to inspect %s: %w", queue, err)
+	}
+	return QueueStats{Ready: q.Messages, Consumers: q.Consumers}, nil
+}
+
+// ManagementStats reads queue stats from the management API, which also
+// reports unacked messages and, for classic queues, the oldest message
+type ManagementStats struct {
+	Client *management.Client
+	VHost  string
+}
+
+func (m *ManagementStats) QueueStats(ctx context.Context, queue string) (QueueStats, error) {
+	vhost := m.VHost
+	if vhost == "" {
+		vhost = "/"
+	}
+	q, err := m.Client.Queue(ctx, vhost, queue)
+	if err != nil {
+		return QueueStats{}, err
+	}
+	stats := QueueStats{Ready: q.MessagesReady, Unacked: q.MessagesUnacknowledged, Consumers: q.Consumers}
+	if q.HeadMessageTimestamp > 0 {
+		stats.Oldest = time.Unix(q.HeadMessageTimestamp, 0)
+	}
+	return stats, nil
+}
+
+// Gauges exported by QueueMonitor, labelled by queue. Every instance
+// exports the same values, so aggregate them with max, not sum. They are
+// prefixed pubsub_ like the other metrics of the package, so they do not
+// clash with the rabbitmq_queue_* series of RabbitMQ's own Prometheus
+// plugin when both are scraped.
+var (
+	queueReady = metrics.Default.NewGaugeVec("pubsub_queue_messages_ready",
+		"Messages waiting in the queue.", "queue")
+	queueUnacked = metrics.Default.NewGaugeVec("pubsub_queue_messages_unacked",
+		"Messages delivered but not yet settled.", "queue")
+	queueConsumers = metrics.Default.NewGaugeVec("pubsub_queue_consumers",
+		"Consumers attached to the queue.", "queue")
+	queueOldestAge = metrics.Default.NewGaugeVec("pubsub_queue_oldest_message_age_seconds",
+		"Age of the oldest message in the queue, 0 when empty or unknown.", "queue")
+	queueScrapeErrors = metrics.Default.NewCounterVec("pubsub_queue_stats_errors_total",
+		"Failed attempts to read queue stats.", "queue")
+)
+
+// QueueMonitor polls queue stats into metrics, so an autoscaler can follow
+// the backlog instead of CPU
+type QueueMonitor struct {
+	Interval time.Duration
+
+	source QueueStatsSource
+	queues []string
+}
+
+// NewQueueMonitor polls queues from source every 15 seconds
+func NewQueueMonitor(source QueueStatsSource, queues ...string) *QueueMonitor {
+	return &QueueMonitor{Interval: 15 * time.Second, source: source, queues: queues}
+}
+
+// Run polls until ctx is done
+func (m *QueueMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		m.Poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll updates the metrics of every queue once
+func (m *QueueMonitor) Poll(ctx context.Context) {
+	for _, queue := range m.queues {
+		stats, err := m.source.QueueStats(ctx, queue)
+		if err != nil {
+			queueScrapeErrors.With(queue).Inc()
+			logger().Warn("failed to read queue stats", "queue", queue, "error", err)
+			continue
+		}
+		queueReady.With(queue).Set(float64(stats.Ready))
+		queueUnacked.With(queue).Set(float64(stats.Unacked))
+		queueConsumers.With(queue).Set(float64(stats.Consumers))
+		age := 0.0
+		if !stats.Oldest.IsZero() && stats.Ready+stats.Unacked > 0 {
+			age = time.Since(stats.Oldest).Seconds()
+		}
+		queueOldestAge.With(queue).Set(age)
+	}
+}
@@ -0,0 +1,266 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/management"
+	"github.com/abdooman21/ecom-plat/internal/metrics"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// managementQueues is what the stub management API serves, by path
+var managementQueues = map[string]string{
+	"/api/queues/%2F/orders_queue": `{"name": "orders_queue", "messages_ready": 1200, "messages_unacknowledged": 35,
+		"consumers": 4, "head_message_timestamp": 1700000000}`,
+	"/api/queues/%2F/orders_stream":    `{"name": "orders_stream", "type": "stream", "messages_ready": 80, "consumers": 1}`,
+	"/api/queues/shop/eu_orders_queue": `{"name": "eu_orders_queue", "messages_ready": 7}`,
+}
+
+func newStubManagement(t *testing.T) *management.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := managementQueues[r.URL.EscapedPath()]
+		if !ok {
+			http.Error(w, `{"error":"Object Not Found","reason":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := management.New(srv.URL)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return c
+}
+
+func TestManagementStats(t *testing.T) {
+	client := newStubManagement(t)
+	tests := []struct {
+		name    string
+		vhost   string
+		queue   string
+		want    QueueStats
+		wantErr string
+	}{
+		{
+			name:  "classic queue with the oldest message",
+			queue: "orders_queue",
+			want:  QueueStats{Ready: 1200, Unacked: 35, Consumers: 4, Oldest: time.Unix(1700000000, 0)},
+		},
+		{
+			name:  "stream without a head timestamp",
+			queue: "orders_stream",
+			want:  QueueStats{Ready: 80, Consumers: 1},
+		},
+		{
+			name:  "other vhost",
+			vhost: "shop",
+			queue: "eu_orders_queue",
+			want:  QueueStats{Ready: 7},
+		},
+		{
+			name:    "missing queue",
+			queue:   "stray_queue",
+			wantErr: "404 Not Found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &ManagementStats{Client: client, VHost: tt.vhost}
+			got, err := m.QueueStats(context.Background(), tt.queue)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("QueueStats error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("QueueStats: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("QueueStats = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakePassiveChannel answers passive declares from queues and closes
+// itself on a missing one, like the broker does
+type fakePassiveChannel struct {
+	queues map[string]amqp.Queue
+	closed bool
+}
+
+func (c *fakePassiveChannel) QueueDeclarePassive(name string, _, _, _, _ bool, _ amqp.Table) (amqp.Queue, error) {
+	if c.closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+	q, ok := c.queues[name]
+	if !ok {
+		c.closed = true
+		return amqp.Queue{}, &amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - no queue '" + name + "'"}
+	}
+	return q, nil
+}
+
+func (c *fakePassiveChannel) IsClosed() bool { return c.closed }
+
+func TestPassiveStats(t *testing.T) {
+	queues := map[string]amqp.Queue{"orders_queue": {Name: "orders_queue", Messages: 1200, Consumers: 4}}
+	opened := 0
+	openErr := error(nil)
+	p := newPassiveStats(func() (passiveChannel, error) {
+		if openErr != nil {
+			return nil, openErr
+		}
+		opened++
+		return &fakePassiveChannel{queues: queues}, nil
+	})
+	ctx := context.Background()
+
+	got, err := p.QueueStats(ctx, "orders_queue")
+	if err != nil {
+		t.Fatalf("QueueStats: %v", err)
+	}
+	if want := (QueueStats{Ready: 1200, Consumers: 4}); got != want {
+		t.Errorf("QueueStats = %+v, want %+v", got, want)
+	}
+	if _, err := p.QueueStats(ctx, "orders_queue"); err != nil || opened != 1 {
+		t.Errorf("second QueueStats = %v with %d channels, want the channel reused", err, opened)
+	}
+
+	// a missing queue closes the channel, and the next call opens another
+	if _, err := p.QueueStats(ctx, "stray_queue"); err == nil || !strings.Contains(err.Error(), "failed to inspect stray_queue") {
+		t.Errorf("QueueStats of a missing queue = %v", err)
+	}
+	if _, err := p.QueueStats(ctx, "orders_queue"); err != nil || opened != 2 {
+		t.Errorf("QueueStats after the channel closed = %v with %d channels, want a new channel", err, opened)
+	}
+
+	p.ch.(*fakePassiveChannel).closed = true
+	openErr = amqp.ErrClosed
+	if _, err := p.QueueStats(ctx, "orders_queue"); !errors.Is(err, amqp.ErrClosed) {
+		t.Errorf("QueueStats without a connection = %v, want %v", err, amqp.ErrClosed)
+	}
+}
+
+// stubStats serves fixed stats, failing for queues in errs
+type stubStats struct {
+	stats map[string]QueueStats
+	errs  map[string]error
+}
+
+func (s *stubStats) QueueStats(_ context.Context, queue string) (QueueStats, error) {
+	if err := s.errs[queue]; err != nil {
+		return QueueStats{}, err
+	}
+	return s.stats[queue], nil
+}
+
+// queueGauge returns the current value of a QueueMonitor metric
+func queueGauge(t *testing.T, name, queue string) float64 {
+	t.Helper()
+	for _, s := range metrics.Default.Gather() {
+		if s.Name == name && s.Labels == `{queue="`+queue+`"}` {
+			return s.Value
+		}
+	}
+	return 0
+}
+
+func TestQueueMonitorPoll(t *testing.T) {
+	now := time.Now()
+	source := &stubStats{stats: map[string]QueueStats{
+		"test_monitor_backlog": {Ready: 1200, Unacked: 35, Consumers: 4, Oldest: now.Add(-90 * time.Second)},
+		"test_monitor_unacked": {Unacked: 3, Consumers: 1, Oldest: now.Add(-30 * time.Second)},
+		"test_monitor_empty":   {Consumers: 2, Oldest: now.Add(-time.Hour)},
+		"test_monitor_unknown": {Ready: 80},
+	}}
+	m := NewQueueMonitor(source, "test_monitor_backlog", "test_monitor_unacked", "test_monitor_empty", "test_monitor_unknown")
+	m.Poll(context.Background())
+
+	tests := []struct {
+		queue                     string
+		ready, unacked, consumers float64
+		age                       float64 // seconds, within a second
+	}{
+		{"test_monitor_backlog", 1200, 35, 4, 90},
+		{"test_monitor_unacked", 0, 3, 1, 30},
+		{"test_monitor_empty", 0, 0, 2, 0}, // the head timestamp of an empty queue is stale
+		{"test_monitor_unknown", 80, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		got := [3]float64{
+			queueGauge(t, "pubsub_queue_messages_ready", tt.queue),
+			queueGauge(t, "pubsub_queue_messages_unacked", tt.queue),
+			queueGauge(t, "pubsub_queue_consumers", tt.queue),
+		}
+		if want := [3]float64{tt.ready, tt.unacked, tt.consumers}; got != want {
+			t.Errorf("%s: ready, unacked, consumers = %v, want %v", tt.queue, got, want)
+		}
+		if age := queueGauge(t, "pubsub_queue_oldest_message_age_seconds", tt.queue); math.Abs(age-tt.age) > 1 {
+			t.Errorf("%s: oldest message age = %vs, want %vs", tt.queue, age, tt.age)
+		}
+	}
+
+	// a failed poll counts the error and keeps the last values
+	errorsBefore := queueGauge(t, "pubsub_queue_stats_errors_total", "test_monitor_backlog")
+	source.errs = map[string]error{"test_monitor_backlog": errors.New("connection refused")}
+	source.stats["test_monitor_unknown"] = QueueStats{Ready: 5}
+	m.Poll(context.Background())
+	if n := queueGauge(t, "pubsub_queue_stats_errors_total", "test_monitor_backlog") - errorsBefore; n != 1 {
+		t.Errorf("errors counted = %v, want 1", n)
+	}
+	if ready := queueGauge(t, "pubsub_queue_messages_ready", "test_monitor_backlog"); ready != 1200 {
+		t.Errorf("ready after a failed poll = %v, want the last value 1200", ready)
+	}
+	if ready := queueGauge(t, "pubsub_queue_messages_ready", "test_monitor_unknown"); ready != 5 {
+		t.Errorf("other queues were not polled after an error: ready = %v, want 5", ready)
+	}
+}
+
+func TestQueueMonitorRun(t *testing.T) {
+	polled := make(chan struct{}, 10)
+	m := NewQueueMonitor(statsFunc(func(context.Context, string) (QueueStats, error) {
+		polled <- struct{}{}
+		return QueueStats{}, nil
+	}), "test_monitor_run")
+	m.Interval = 5 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-polled:
+		case <-time.After(time.Second):
+			t.Fatalf("poll %d did not happen", i+1)
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after the context was cancelled")
+	}
+}
+
+// statsFunc adapts a function to QueueStatsSource
+type statsFunc func(ctx context.Context, queue string) (QueueStats, error)
+
+func (f statsFunc) QueueStats(ctx context.Context, queue string) (QueueStats, error) {
+	return f(ctx, queue)
+}
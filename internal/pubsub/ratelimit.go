@@ -0,0 +1,152 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting how fast handlers run. One limiter
+// can be given to a single subscription, or shared by several to enforce a
+// global quota (e.g. the shipping carrier's API limit). The rate can be
+// changed at runtime with SetRate.
+type RateLimiter struct {
+	Name string
+
+	mu     sync.Mutex
+	rate   float64 // tokens per second, <= 0 means unlimited
+	burst  float64
+	tokens float64
+	last   time.Time
+	stats  RateLimiterStats
+	// changed is closed and replaced by SetRate to wake the waiters
+	changed chan struct{}
+}
+
+// RateLimiterStats counts what a RateLimiter did
+type RateLimiterStats struct {
+	Allowed   int           // messages let through
+	Throttled int           // messages that had to wait for a token
+	Waited    time.Duration // total time spent waiting
+}
+
+// NewRateLimiter allows perSecond messages on average with bursts of up to
+// burst messages
+func NewRateLimiter(name string, perSecond float64, burst int) *RateLimiter {
+	burst = max(burst, 1)
+	l := &RateLimiter{
+		Name:    name,
+		rate:    perSecond,
+		burst:   float64(burst),
+		tokens:  float64(burst),
+		last:    time.Now(),
+		changed: make(chan struct{}),
+	}
+	l.report()
+	return l
+}
+
+// SetRate changes the limit; waiting handlers pick it up at once
+func (l *RateLimiter) SetRate(perSecond float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate = perSecond
+	l.burst = float64(max(burst, 1))
+	l.tokens = min(l.tokens, l.burst)
+	l.report()
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// report exports the current limit; must be called with mu held or
+// before the limiter is shared
+func (l *RateLimiter) report() {
+	limiterRate.With(l.Name).Set(l.rate)
+	limiterBurst.With(l.Name).Set(l.burst)
+}
+
+// Rate returns the current limit in messages per second
+func (l *RateLimiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Stats returns the limiter's counters
+func (l *RateLimiter) Stats() RateLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+// Wait blocks until a token is available or ctx is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n tokens are available or ctx is done. Requests
+// larger than the burst are allowed once the bucket is full.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	start := time.Now()
+	throttled := false
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.refill(now)
+		need := min(float64(n), l.burst)
+		if l.rate <= 0 || l.tokens >= need {
+			if l.rate > 0 {
+				l.tokens -= float64(n)
+			}
+			l.stats.Allowed += n
+			if throttled {
+				l.stats.Throttled += n
+				l.stats.Waited += now.Sub(start)
+				limiterThrottled.With(l.Name).Add(float64(n))
+				limiterWait.With(l.Name).Add(now.Sub(start).Seconds())
+			}
+			l.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((need - l.tokens) / l.rate * float64(time.Second))
+		changed := l.changed
+		l.mu.Unlock()
+
+		throttled = true
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		}
+	}
+}
+
+// refill must be called with mu held
+func (l *RateLimiter) refill(now time.Time) {
+	if l.rate > 0 {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+}
+
+// WithRateLimit makes the subscription wait for a token from every given
+// limiter before running the handler, e.g. its own limiter plus one shared
+// by all subscriptions calling the same partner
+func WithRateLimit(limiters ...*RateLimiter) Option {
+	return func(o *options) {
+		o.limiters = append(o.limiters, limiters...)
+	}
+}
+
+// waitLimiters takes n tokens from each limiter
+func waitLimiters(limiters []*RateLimiter, n int) {
+	for _, l := range limiters {
+		// a background context never fails
+		_ = l.WaitN(context.Background(), n)
+	}
+}
@@ -0,0 +1,105 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/metrics"
+)
+
+func TestRateLimiterWaitN(t *testing.T) {
+	tests := []struct {
+		name      string
+		perSecond float64
+		burst     int
+		takes     []int
+		minWait   time.Duration
+		maxWait   time.Duration
+		throttled int
+	}{
+		{"within the burst", 10, 5, []int{1, 1, 1, 1, 1}, 0, 50 * time.Millisecond, 0},
+		{"beyond the burst", 100, 2, []int{1, 1, 1, 1}, 15 * time.Millisecond, 500 * time.Millisecond, 2},
+		{"unlimited", 0, 1, []int{100, 100, 100}, 0, 50 * time.Millisecond, 0},
+		{"larger than the burst waits for a full bucket", 100, 2, []int{2, 5}, 15 * time.Millisecond, 500 * time.Millisecond, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter("test-"+tt.name, tt.perSecond, tt.burst)
+			start := time.Now()
+			total := 0
+			for _, n := range tt.takes {
+				if err := l.WaitN(context.Background(), n); err != nil {
+					t.Fatalf("WaitN(%d): %v", n, err)
+				}
+				total += n
+			}
+			if took := time.Since(start); took < tt.minWait || took > tt.maxWait {
+				t.Errorf("took %v, want between %v and %v", took, tt.minWait, tt.maxWait)
+			}
+			st := l.Stats()
+			if st.Allowed != total || st.Throttled != tt.throttled {
+				t.Errorf("stats = %+v, want allowed %d, throttled %d", st, total, tt.throttled)
+			}
+		})
+	}
+}
+
+func TestRateLimiterWaitCancelled(t *testing.T) {
+	l := NewRateLimiter("test-cancel", 0.1, 1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait on an empty bucket = %v, want deadline exceeded", err)
+	}
+	if st := l.Stats(); st.Allowed != 1 {
+		t.Errorf("allowed %d, want 1", st.Allowed)
+	}
+}
+
+func TestRateLimiterSetRate(t *testing.T) {
+	l := NewRateLimiter("test-setrate", 0.1, 1)
+	l.Wait(context.Background())
+
+	// a waiter blocked on the old, slow rate picks up the new one
+	done := make(chan error)
+	go func() { done <- l.Wait(context.Background()) }()
+	time.Sleep(10 * time.Millisecond)
+	l.SetRate(1000, 10)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the waiter did not pick up the new rate")
+	}
+	if r := l.Rate(); r != 1000 {
+		t.Errorf("Rate = %v, want 1000", r)
+	}
+}
+
+func TestRateLimiterGauges(t *testing.T) {
+	gauge := func(name, limiter string) float64 {
+		for _, s := range metrics.Default.Gather() {
+			if s.Name == name && s.Labels == `{limiter="`+limiter+`"}` {
+				return s.Value
+			}
+		}
+		t.Fatalf("no %s sample for %s", name, limiter)
+		return 0
+	}
+
+	l := NewRateLimiter("test-gauges", 50, 0)
+	if r, b := gauge("pubsub_rate_limit_per_second", l.Name), gauge("pubsub_rate_limit_burst", l.Name); r != 50 || b != 1 {
+		t.Errorf("after NewRateLimiter: rate %v, burst %v; want 50, 1", r, b)
+	}
+	l.SetRate(200, 20)
+	if r, b := gauge("pubsub_rate_limit_per_second", l.Name), gauge("pubsub_rate_limit_burst", l.Name); r != 200 || b != 20 {
+		t.Errorf("after SetRate: rate %v, burst %v; want 200, 20", r, b)
+	}
+}
@@ -0,0 +1,335 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// directReplyTo is the pseudo-queue RabbitMQ uses for direct reply-to
+	directReplyTo = "amq.rabbitmq.reply-to"
+	// rpcErrorHeader carries the handler error back to the caller
+	rpcErrorHeader = "x-rpc-error"
+)
+
+// ErrNoRoute is returned by Call when no queue is bound for the request key
+var ErrNoRoute = errors.New("rpc: request was not routed to any queue")
+
+// ErrClientClosed is returned by Call once the client's channel is gone
+var ErrClientClosed = errors.New("rpc: client closed")
+
+// RemoteError is an error returned by the server-side handler
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "rpc: remote error: " + e.Message
+}
+
+// RPCClient issues requests on its own channel and receives the replies
+// through direct reply-to, matching them to callers by correlation id.
+// It is safe for concurrent use.
+type RPCClient struct {
+	// Timeout bounds calls whose context has no deadline
+	Timeout time.Duration
+
+	ch      rpcChannel
+	mu      sync.Mutex // guards pending and closed
+	pending map[string]chan rpcResult
+	closed  bool
+}
+
+// rpcChannel is the channel an RPCClient publishes requests on, an
+// *amqp.Channel outside of tests
+type rpcChannel interface {
+	PublishChannel
+	Close() error
+}
+
+type rpcResult struct {
+	delivery amqp.Delivery
+	err      error
+}
+
+// NewRPCClient opens a channel on conn and starts listening for replies
+func NewRPCClient(conn *amqp.Connection) (*RPCClient, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+	// direct reply-to requires consuming in no-ack mode before publishing
+	replies, err := ch.Consume(directReplyTo, "", true, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to consume replies: %w", err)
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+	return newRPCClient(ch, replies, returns), nil
+}
+
+// newRPCClient matches replies and returns to calls published on ch until
+// both channels are closed, which happens when ch is
+func newRPCClient(ch rpcChannel, replies <-chan amqp.Delivery, returns <-chan amqp.Return) *RPCClient {
+	c := &RPCClient{
+		Timeout: 10 * time.Second,
+		ch:      ch,
+		pending: make(map[string]chan rpcResult),
+	}
+	go c.dispatch(replies, returns)
+	return c
+}
+
+// Close closes the client's channel and fails all in-flight calls
+func (c *RPCClient) Close() error {
+	return c.ch.Close()
+}
+
+func (c *RPCClient) dispatch(replies <-chan amqp.Delivery, returns <-chan amqp.Return) {
+	for replies != nil || returns != nil {
+		select {
+		case d, ok := <-replies:
+			if !ok {
+				replies = nil
+				continue
+			}
+			c.resolve(d.CorrelationId, rpcResult{delivery: d})
+		case r, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			c.resolve(r.CorrelationId, rpcResult{err: fmt.Errorf("%w: %s", ErrNoRoute, r.ReplyText)})
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for id, waiter := range c.pending {
+		waiter <- rpcResult{err: ErrClientClosed}
+		delete(c.pending, id)
+	}
+}
+
+func (c *RPCClient) resolve(correlationID string, res rpcResult) {
+	c.mu.Lock()
+	waiter, ok := c.pending[correlationID]
+	delete(c.pending, correlationID)
+	c.mu.Unlock()
+	if !ok {
+		// the caller already gave up
+		return
+	}
+	waiter <- res
+}
+
+func (c *RPCClient) send(ctx context.Context, exchange, key string, msg amqp.Publishing) (chan rpcResult, error) {
+	waiter := make(chan rpcResult, 1)
+
+	// register before publishing, as the reply may beat PublishWithContext
+	// back, but do not hold mu across the publish: a blocked publish
+	// (e.g. under a connection.blocked alarm) would stall every Call
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClientClosed
+	}
+	c.pending[msg.CorrelationId] = waiter
+	c.mu.Unlock()
+
+	// mandatory, so an unroutable request comes back as ErrNoRoute
+	if err := c.ch.PublishWithContext(ctx, exchange, key, true, false, msg); err != nil {
+		c.forget(msg.CorrelationId)
+		return nil, fmt.Errorf("failed to publish request: %w", err)
+	}
+	return waiter, nil
+}
+
+func (c *RPCClient) forget(correlationID string) {
+	c.mu.Lock()
+	delete(c.pending, correlationID)
+	c.mu.Unlock()
+}
+
+// Call publishes req as JSON to exchange/key and waits for the typed reply.
+// It fails with the context error on timeout, ErrNoRoute when nothing
+// consumes the key, or a *RemoteError when the server handler failed.
+func Call[Req, Resp any](ctx context.Context, c *RPCClient, exchange, key string, req Req) (*Resp, error) {
+	if _, ok := ctx.Deadline(); !ok && c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	msg := newPublishing("application/json", key, body, req, nil)
+	msg.CorrelationId = newCorrelationID()
+	msg.ReplyTo = directReplyTo
+	if deadline, ok := ctx.Deadline(); ok {
+		// let the broker drop requests nobody is waiting for anymore
+		ttl := time.Until(deadline).Milliseconds()
+		if ttl < 1 {
+			return nil, context.DeadlineExceeded
+		}
+		msg.Expiration = strconv.FormatInt(ttl, 10)
+	}
+
+	ctx, span := startPublishSpan(ctx, exchange, key, &msg)
+	span.Name = "call " + key
+	span.Kind = tracing.KindClient
+	defer span.End()
+
+	waiter, err := c.send(ctx, exchange, key, msg)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		c.forget(msg.CorrelationId)
+		span.SetError(ctx.Err())
+		return nil, fmt.Errorf("rpc call %s: %w", key, ctx.Err())
+	case res := <-waiter:
+		if res.err != nil {
+			span.SetError(res.err)
+			return nil, res.err
+		}
+		if remote, ok := res.delivery.Headers[rpcErrorHeader].(string); ok {
+			span.SetError(&RemoteError{Message: remote})
+			return nil, &RemoteError{Message: remote}
+		}
+		var resp Resp
+		if err := json.Unmarshal(res.delivery.Body, &resp); err != nil {
+			return nil, fmt.Errorf("failed to decode reply: %w", err)
+		}
+		return &resp, nil
+	}
+}
+
+// Serve consumes requests from queueName and answers each one on its
+// ReplyTo queue. A handler error, or a request that cannot be decoded,
+// is sent back in the x-rpc-error header and surfaces as a *RemoteError
+// in Call. Requests are always acked: retrying is up to the caller.
+func Serve[Req, Resp any](
+	conn *amqp.Connection,
+	exchange,
+	queueName,
+	key string,
+	queueType SimpleQueueType,
+	handler func(context.Context, *Req) (*Resp, error),
+	opts ...Option,
+) error {
+	ch, _, err := DeclareAndBind(conn, exchange, queueName, key, queueType, opts...)
+	if err != nil {
+		return fmt.Errorf("at declaring and binding: %w", err)
+	}
+	o := newOptions(opts)
+	if err := ch.Qos(o.prefetch, 0, false); err != nil {
+		return fmt.Errorf("failed prefetch limit: %w", err)
+	}
+	msgs, err := ch.Consume(queueName, "", false, false, false, false, consumeArgs(queueType, o))
+	if err != nil {
+		return fmt.Errorf("failed to register consumer: %w", err)
+	}
+
+	lg := o.logger.With(slog.String("queue", queueName))
+	go serveRequests(ch, msgs, queueName, handler, o.limiters, lg)
+
+	return nil
+}
+
+// serveRequests answers the requests in msgs on ch until msgs is closed
+func serveRequests[Req, Resp any](
+	ch PublishChannel,
+	msgs <-chan amqp.Delivery,
+	queueName string,
+	handler func(context.Context, *Req) (*Resp, error),
+	limiters []*RateLimiter,
+	lg *slog.Logger,
+) {
+	for d := range msgs {
+		waitLimiters(limiters, 1)
+		reply := amqp.Publishing{
+			ContentType:   "application/json",
+			CorrelationId: d.CorrelationId,
+			Timestamp:     time.Now().UTC(),
+		}
+		ctx, span := startConsumeSpan(context.Background(), d, queueName)
+		span.Name = "serve " + d.RoutingKey
+		span.Kind = tracing.KindServer
+		if resp, err := serveOne(ctx, d, handler); err != nil {
+			span.SetError(err)
+			reply.Headers = amqp.Table{rpcErrorHeader: err.Error()}
+		} else if reply.Body, err = json.Marshal(resp); err != nil {
+			reply.Headers = amqp.Table{rpcErrorHeader: "failed to encode reply: " + err.Error()}
+		}
+
+		if d.ReplyTo == "" {
+			lg.Warn("rpc request has no reply-to, dropping reply", deliveryAttrs(d)...)
+		} else if err := ch.PublishWithContext(context.Background(), "", d.ReplyTo, false, false, reply); err != nil {
+			lg.Error("failed to publish rpc reply", append(deliveryAttrs(d), "error", err)...)
+		}
+		d.Ack(false)
+		span.End()
+	}
+}
+
+// serveOne decodes a request and runs the handler with a context carrying
+// the server span and bounded by the caller's deadline
+func serveOne[Req, Resp any](ctx context.Context, d amqp.Delivery, handler func(context.Context, *Req) (*Resp, error)) (*Resp, error) {
+	if deadline, ok := requestDeadline(d, time.Now()); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+		if ctx.Err() != nil {
+			// the caller gave up while the request sat in the queue
+			return nil, fmt.Errorf("request expired before it was handled: %w", ctx.Err())
+		}
+	}
+
+	req, err := JSONUnmarshaller[Req](d.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode request: %w", err)
+	}
+	return handler(ctx, req)
+}
+
+// requestDeadline is when the caller stops waiting for d: Call sets the
+// expiration to the time it has left, so the deadline is the publish
+// timestamp plus that TTL, not now plus the TTL, which would ignore the
+// time spent in the queue. AMQP timestamps only have second precision, so
+// the deadline may fall up to a second early, never late. Requests
+// without a timestamp count from now.
+func requestDeadline(d amqp.Delivery, now time.Time) (time.Time, bool) {
+	ms, err := strconv.ParseInt(d.Expiration, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	sent := d.Timestamp
+	if sent.IsZero() || sent.After(now) {
+		sent = now
+	}
+	return sent.Add(time.Duration(ms) * time.Millisecond), true
+}
+
+func newCorrelationID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,434 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeRPCChannel hands every request to respond, which plays the broker
+// and the server by writing to replies or returns
+type fakeRPCChannel struct {
+	respond func(c *fakeRPCChannel, key string, mandatory bool, msg amqp.Publishing)
+	replies chan amqp.Delivery
+	returns chan amqp.Return
+
+	closeOnce sync.Once
+}
+
+func newFakeRPCChannel(respond func(c *fakeRPCChannel, key string, mandatory bool, msg amqp.Publishing)) *fakeRPCChannel {
+	return &fakeRPCChannel{
+		respond: respond,
+		replies: make(chan amqp.Delivery, 100),
+		returns: make(chan amqp.Return, 100),
+	}
+}
+
+func (c *fakeRPCChannel) PublishWithContext(_ context.Context, _, key string, mandatory, _ bool, msg amqp.Publishing) error {
+	if c.respond != nil {
+		c.respond(c, key, mandatory, msg)
+	}
+	return nil
+}
+
+// Close closes the consumer and return channels, like closing an
+// *amqp.Channel does
+func (c *fakeRPCChannel) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.replies)
+		close(c.returns)
+	})
+	return nil
+}
+
+func (c *fakeRPCChannel) client() *RPCClient {
+	return newRPCClient(c, c.replies, c.returns)
+}
+
+type stockRequest struct {
+	SKU string `json:"sku"`
+}
+
+type stockReply struct {
+	SKU     string `json:"sku"`
+	InStock int    `json:"in_stock"`
+}
+
+func replyWith(headers amqp.Table, body string) func(*fakeRPCChannel, string, bool, amqp.Publishing) {
+	return func(c *fakeRPCChannel, _ string, _ bool, msg amqp.Publishing) {
+		c.replies <- amqp.Delivery{CorrelationId: msg.CorrelationId, Headers: headers, Body: []byte(body)}
+	}
+}
+
+func TestCall(t *testing.T) {
+	tests := []struct {
+		name    string
+		respond func(*fakeRPCChannel, string, bool, amqp.Publishing)
+		want    *stockReply
+		wantErr func(error) bool
+	}{
+		{
+			name:    "reply",
+			respond: replyWith(nil, `{"sku":"SKU-1","in_stock":3}`),
+			want:    &stockReply{SKU: "SKU-1", InStock: 3},
+		},
+		{
+			name:    "remote error",
+			respond: replyWith(amqp.Table{rpcErrorHeader: "warehouse offline"}, ""),
+			wantErr: func(err error) bool {
+				var remote *RemoteError
+				return errors.As(err, &remote) && remote.Message == "warehouse offline"
+			},
+		},
+		{
+			name: "returned as unroutable",
+			respond: func(c *fakeRPCChannel, key string, mandatory bool, msg amqp.Publishing) {
+				if !mandatory {
+					return // and let the call time out
+				}
+				c.returns <- amqp.Return{CorrelationId: msg.CorrelationId, ReplyText: "NO_ROUTE", RoutingKey: key}
+			},
+			wantErr: func(err error) bool { return errors.Is(err, ErrNoRoute) },
+		},
+		{
+			name: "reply for another call is ignored",
+			respond: func(c *fakeRPCChannel, key string, mandatory bool, msg amqp.Publishing) {
+				msg.CorrelationId = "someone-else"
+				replyWith(nil, `{}`)(c, key, mandatory, msg)
+			},
+			wantErr: func(err error) bool { return errors.Is(err, context.DeadlineExceeded) },
+		},
+		{
+			name:    "undecodable reply",
+			respond: replyWith(nil, `not json`),
+			wantErr: func(err error) bool { return err != nil && strings.Contains(err.Error(), "decode reply") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := newFakeRPCChannel(tt.respond)
+			c := ch.client()
+			defer c.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			got, err := Call[stockRequest, stockReply](ctx, c, "peril_rpc", "rpc.stock.check", stockRequest{SKU: "SKU-1"})
+			if tt.wantErr != nil {
+				if !tt.wantErr(err) {
+					t.Fatalf("Call: err = %v, reply %+v", err, got)
+				}
+			} else if err != nil || *got != *tt.want {
+				t.Fatalf("Call = %+v, %v; want %+v", got, err, tt.want)
+			}
+			if n := pendingCalls(c); n != 0 {
+				t.Errorf("%d calls still pending", n)
+			}
+		})
+	}
+}
+
+func pendingCalls(c *RPCClient) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+func TestCallPublishesRequest(t *testing.T) {
+	var got amqp.Publishing
+	ch := newFakeRPCChannel(func(c *fakeRPCChannel, key string, mandatory bool, msg amqp.Publishing) {
+		got = msg
+		replyWith(nil, `{}`)(c, key, mandatory, msg)
+	})
+	c := ch.client()
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := Call[stockRequest, stockReply](ctx, c, "peril_rpc", "rpc.stock.check", stockRequest{SKU: "SKU-1"}); err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if got.ReplyTo != directReplyTo || got.CorrelationId == "" || got.ContentType != "application/json" {
+		t.Errorf("request = %+v, want a JSON request with a correlation id replying to %s", got, directReplyTo)
+	}
+	// the broker drops the request once the caller would have given up
+	if ttl, err := time.ParseDuration(got.Expiration + "ms"); err != nil || ttl <= 0 || ttl > 2*time.Second {
+		t.Errorf("expiration = %q, want the time left of the 2s deadline", got.Expiration)
+	}
+}
+
+func TestCallMatchesRepliesByCorrelationID(t *testing.T) {
+	const calls = 20
+	var mu sync.Mutex
+	var requests []amqp.Publishing
+	ch := newFakeRPCChannel(func(_ *fakeRPCChannel, _ string, _ bool, msg amqp.Publishing) {
+		mu.Lock()
+		requests = append(requests, msg)
+		mu.Unlock()
+	})
+	c := ch.client()
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sku := fmt.Sprintf("SKU-%d", i)
+			got, err := Call[stockRequest, stockReply](context.Background(), c, "peril_rpc", "rpc.stock.check", stockRequest{SKU: sku})
+			if err != nil {
+				errs <- err
+			} else if got.SKU != sku {
+				errs <- fmt.Errorf("call for %s got the reply for %s", sku, got.SKU)
+			}
+		}()
+	}
+	waitFor(t, "the requests", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(requests) == calls
+	})
+
+	// answer in reverse order, echoing the SKU of each request
+	for i := calls - 1; i >= 0; i-- {
+		var req stockRequest
+		json.Unmarshal(requests[i].Body, &req)
+		body, _ := json.Marshal(stockReply{SKU: req.SKU})
+		ch.replies <- amqp.Delivery{CorrelationId: requests[i].CorrelationId, Body: body}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestCallLateReplyAfterTimeout(t *testing.T) {
+	var request amqp.Publishing
+	published := make(chan struct{})
+	ch := newFakeRPCChannel(func(_ *fakeRPCChannel, _ string, _ bool, msg amqp.Publishing) {
+		request = msg
+		close(published)
+	})
+	c := ch.client()
+	defer c.Close()
+	c.Timeout = 20 * time.Millisecond
+
+	_, err := Call[stockRequest, stockReply](context.Background(), c, "peril_rpc", "rpc.stock.check", stockRequest{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Call: err = %v, want deadline exceeded from the client Timeout", err)
+	}
+	<-published
+	if n := pendingCalls(c); n != 0 {
+		t.Errorf("%d calls still pending after the timeout", n)
+	}
+
+	// the late reply is dropped and does not block the dispatcher
+	ch.replies <- amqp.Delivery{CorrelationId: request.CorrelationId, Body: []byte(`{}`)}
+	ch.replies <- amqp.Delivery{CorrelationId: request.CorrelationId, Body: []byte(`{}`)}
+	waitFor(t, "the late replies to be dropped", func() bool { return len(ch.replies) == 0 })
+}
+
+func TestCallDoesNotWaitForOtherPublishes(t *testing.T) {
+	release := make(chan struct{})
+	ch := newFakeRPCChannel(func(c *fakeRPCChannel, key string, mandatory bool, msg amqp.Publishing) {
+		if key == "rpc.slow" {
+			<-release // e.g. a connection.blocked alarm
+		}
+		replyWith(nil, `{}`)(c, key, mandatory, msg)
+	})
+	c := ch.client()
+	defer c.Close()
+
+	slow := make(chan error)
+	go func() {
+		_, err := Call[stockRequest, stockReply](context.Background(), c, "peril_rpc", "rpc.slow", stockRequest{})
+		slow <- err
+	}()
+	waitFor(t, "the slow call", func() bool { return pendingCalls(c) == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := Call[stockRequest, stockReply](ctx, c, "peril_rpc", "rpc.fast", stockRequest{}); err != nil {
+		t.Errorf("a call behind a blocked publish: %v", err)
+	}
+	close(release)
+	if err := <-slow; err != nil {
+		t.Errorf("slow call: %v", err)
+	}
+}
+
+func TestRPCClientClose(t *testing.T) {
+	ch := newFakeRPCChannel(nil)
+	c := ch.client()
+
+	inflight := make(chan error)
+	go func() {
+		_, err := Call[stockRequest, stockReply](context.Background(), c, "peril_rpc", "rpc.stock.check", stockRequest{})
+		inflight <- err
+	}()
+	waitFor(t, "the call", func() bool { return pendingCalls(c) == 1 })
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case err := <-inflight:
+		if !errors.Is(err, ErrClientClosed) {
+			t.Errorf("in-flight call: err = %v, want %v", err, ErrClientClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not fail the in-flight call")
+	}
+	waitFor(t, "the client to close", func() bool {
+		_, err := Call[stockRequest, stockReply](context.Background(), c, "peril_rpc", "rpc.stock.check", stockRequest{})
+		return errors.Is(err, ErrClientClosed)
+	})
+}
+
+// countingAcker counts acks; serveRequests never nacks
+type countingAcker struct {
+	mu   sync.Mutex
+	acks int
+}
+
+func (a *countingAcker) Ack(uint64, bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acks++
+	return nil
+}
+
+func (a *countingAcker) Nack(uint64, bool, bool) error { return errors.New("unexpected nack") }
+func (a *countingAcker) Reject(uint64, bool) error     { return errors.New("unexpected reject") }
+
+// serverChannel delivers the replies serveRequests publishes to a client
+type serverChannel struct {
+	client *fakeRPCChannel
+}
+
+func (s serverChannel) PublishWithContext(_ context.Context, exchange, key string, _, _ bool, msg amqp.Publishing) error {
+	if exchange != "" || key != directReplyTo {
+		return fmt.Errorf("reply published to %q/%q", exchange, key)
+	}
+	s.client.replies <- amqp.Delivery{CorrelationId: msg.CorrelationId, Headers: msg.Headers, Body: msg.Body}
+	return nil
+}
+
+func TestCallServeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		request any
+		want    *stockReply
+		wantErr string // of the RemoteError
+	}{
+		{"reply", stockRequest{SKU: "SKU-1"}, &stockReply{SKU: "SKU-1", InStock: 7}, ""},
+		{"handler error", stockRequest{SKU: "SKU-404"}, nil, "unknown sku SKU-404"},
+		{"undecodable request", "SKU-1", nil, "failed to decode request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acker := &countingAcker{}
+			requests := make(chan amqp.Delivery, 1)
+			client := newFakeRPCChannel(func(_ *fakeRPCChannel, key string, _ bool, msg amqp.Publishing) {
+				requests <- amqp.Delivery{
+					Acknowledger:  acker,
+					RoutingKey:    key,
+					CorrelationId: msg.CorrelationId,
+					ReplyTo:       msg.ReplyTo,
+					Expiration:    msg.Expiration,
+					Timestamp:     msg.Timestamp,
+					Headers:       msg.Headers,
+					Body:          msg.Body,
+				}
+			})
+			c := client.client()
+			defer c.Close()
+
+			handler := func(_ context.Context, req *stockRequest) (*stockReply, error) {
+				if req.SKU == "SKU-404" {
+					return nil, errors.New("unknown sku " + req.SKU)
+				}
+				return &stockReply{SKU: req.SKU, InStock: 7}, nil
+			}
+			served := make(chan struct{})
+			go func() {
+				serveRequests(serverChannel{client}, requests, "stock_check_queue", handler, nil, slog.New(slog.DiscardHandler))
+				close(served)
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			got, err := Call[any, stockReply](ctx, c, "peril_rpc", "rpc.stock.check", tt.request)
+			close(requests)
+			<-served
+
+			if tt.wantErr != "" {
+				var remote *RemoteError
+				if !errors.As(err, &remote) || !strings.Contains(remote.Message, tt.wantErr) {
+					t.Errorf("Call: err = %v, want a RemoteError with %q", err, tt.wantErr)
+				}
+			} else if err != nil || *got != *tt.want {
+				t.Errorf("Call = %+v, %v; want %+v", got, err, tt.want)
+			}
+			if acker.acks != 1 {
+				t.Errorf("request acked %d times, want once", acker.acks)
+			}
+		})
+	}
+}
+
+func TestServeSkipsExpiredRequests(t *testing.T) {
+	called := false
+	handler := func(context.Context, *stockRequest) (*stockReply, error) {
+		called = true
+		return &stockReply{}, nil
+	}
+	// the caller waited 1s, but the request sat in the queue for 5s
+	d := amqp.Delivery{
+		Expiration: "1000",
+		Timestamp:  time.Now().Add(-5 * time.Second),
+		Body:       []byte(`{"sku":"SKU-1"}`),
+	}
+	if _, err := serveOne(context.Background(), d, handler); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("serveOne: err = %v, want deadline exceeded", err)
+	}
+	if called {
+		t.Error("the handler ran for a request its caller gave up on")
+	}
+}
+
+func TestRequestDeadline(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		expiration string
+		timestamp  time.Time
+		want       time.Time
+		wantOK     bool
+	}{
+		{"no expiration", "", now.Add(-time.Second), time.Time{}, false},
+		{"invalid expiration", "soon", now, time.Time{}, false},
+		{"time spent queued counts", "5000", now.Add(-3 * time.Second), now.Add(2 * time.Second), true},
+		{"already expired", "1000", now.Add(-3 * time.Second), now.Add(-2 * time.Second), true},
+		{"no timestamp counts from now", "5000", time.Time{}, now.Add(5 * time.Second), true},
+		{"timestamp ahead of our clock counts from now", "5000", now.Add(time.Minute), now.Add(5 * time.Second), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := amqp.Delivery{Expiration: tt.expiration, Timestamp: tt.timestamp}
+			got, ok := requestDeadline(d, now)
+			if ok != tt.wantOK || !got.Equal(tt.want) {
+				t.Errorf("requestDeadline = %v, %v; want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
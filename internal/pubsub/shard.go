@@ -0,0 +1,351 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/abdooman21/ecom-plat/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ShardedQueue is a logical queue split across Shards physical queues
+// named "<Name>.shard-<i>". Messages reach the shards through a
+// consistent-hash exchange (rabbitmq_consistent_hash_exchange plugin)
+// that hashes the routing key, so all messages of one order land on the
+// same shard and publishers do not change.
+type ShardedQueue struct {
+	Name   string
+	Shards int
+}
+
+// ShardName returns the name of the i-th physical queue
+func (q ShardedQueue) ShardName(i int) string {
+	return q.Name + ".shard-" + strconv.Itoa(i)
+}
+
+// HashExchange returns the name of the consistent-hash exchange feeding the shards
+func (q ShardedQueue) HashExchange() string {
+	return q.Name + ".hash"
+}
+
+// shardOptions makes shard queues single-active-consumer, so a shard
+// handed over during a rebalance is never consumed by two instances at
+// once: the new owner stands by until the previous one cancels.
+func shardOptions(opts []Option) []Option {
+	return append(slices.Clone(opts), func(o *options) {
+		o.queue.SingleActiveConsumer = true
+	})
+}
+
+// DeclareShards declares the consistent-hash exchange, binds it to
+// exchange with key, then declares every shard queue with an equal weight
+func DeclareShards(
+	conn *amqp.Connection,
+	exchange,
+	key string,
+	q ShardedQueue,
+	queueType SimpleQueueType,
+	opts ...Option,
+) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer ch.Close()
+
+	if err := ch.ExchangeDeclare(q.HashExchange(), "x-consistent-hash", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare consistent-hash exchange: %w", err)
+	}
+	if err := ch.ExchangeBind(q.HashExchange(), key, exchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind consistent-hash exchange: %w", err)
+	}
+
+	for i := 0; i < q.Shards; i++ {
+		// the binding key of a consistent-hash exchange is the shard weight
+		shardCh, _, err := DeclareAndBind(conn, q.HashExchange(), q.ShardName(i), "1", queueType, shardOptions(opts)...)
+		if err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+		shardCh.Close()
+	}
+	return nil
+}
+
+// ConsumerGroup spreads the shards of a ShardedQueue over the instances
+// that joined the group. Members announce themselves with heartbeats on
+// the group exchange; every member computes the same assignment from the
+// live membership (rendezvous hashing) and starts or stops its shard
+// consumers whenever an instance comes or goes.
+type ConsumerGroup struct {
+	queue    ShardedQueue
+	member   string
+	interval time.Duration
+	ch       groupChannel
+	start    func(shard int) (shardConsumer, error)
+	log      *slog.Logger
+
+	members   map[string]time.Time // last heartbeat per member
+	running   map[int]shardConsumer
+	releasing sync.WaitGroup // shard consumers still draining after a rebalance
+	assigned  chan []int
+	done      chan struct{}
+}
+
+// groupChannel publishes the heartbeats of a group
+type groupChannel interface {
+	PublishChannel
+	Close() error
+}
+
+// shardConsumer is a running shard consumer, a *consumer outside of tests
+type shardConsumer interface {
+	stop()
+}
+
+func newConsumerGroup(q ShardedQueue, member string, ch groupChannel, start func(int) (shardConsumer, error), log *slog.Logger) *ConsumerGroup {
+	g := &ConsumerGroup{
+		queue:    q,
+		member:   member,
+		interval: 5 * time.Second,
+		ch:       ch,
+		start:    start,
+		log:      log.With(slog.String("group", q.Name), slog.String("member", member)),
+		members:  map[string]time.Time{member: time.Now()},
+		running:  make(map[int]shardConsumer),
+		assigned: make(chan []int, 1),
+		done:     make(chan struct{}),
+	}
+	g.assigned <- nil
+	return g
+}
+
+type groupHeartbeat struct {
+	Member  string `json:"member"`
+	Leaving bool   `json:"leaving"`
+}
+
+// SubscribeSharded declares the shards of q, joins its consumer group as
+// member and consumes the shards assigned to it until ctx is cancelled.
+// member must be unique per instance, e.g. the pod name.
+func SubscribeSharded[T any](
+	ctx context.Context,
+	conn *amqp.Connection,
+	exchange,
+	key string,
+	q ShardedQueue,
+	queueType SimpleQueueType,
+	member string,
+	handler func(*T) AckType,
+	unmarshaller func([]byte) (*T, error),
+	opts ...Option,
+) (*ConsumerGroup, error) {
+	if err := DeclareShards(conn, exchange, key, q, queueType, opts...); err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+	if err := ch.ExchangeDeclare(routing.ExchangePerilGroup, "topic", true, false, false, false, nil); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to declare group exchange: %w", err)
+	}
+	beatQueue, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to declare heartbeat queue: %w", err)
+	}
+	if err := ch.QueueBind(beatQueue.Name, groupKey(q), routing.ExchangePerilGroup, false, nil); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to bind heartbeat queue: %w", err)
+	}
+	beats, err := ch.Consume(beatQueue.Name, "", true, true, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to consume heartbeats: %w", err)
+	}
+
+	shardOpts := shardOptions(opts)
+	start := func(shard int) (shardConsumer, error) {
+		return startConsumer(conn, q.HashExchange(), q.ShardName(shard), "1", queueType, withoutContext(handler), bodyDecoder(unmarshaller), shardOpts...)
+	}
+	g := newConsumerGroup(q, member, ch, start, newOptions(opts).logger)
+	go g.run(ctx, beats)
+	return g, nil
+}
+
+// Assigned returns the shards this member currently consumes
+func (g *ConsumerGroup) Assigned() []int {
+	shards := <-g.assigned
+	g.assigned <- shards
+	return slices.Clone(shards)
+}
+
+// Done is closed once the member has left the group and stopped its consumers
+func (g *ConsumerGroup) Done() <-chan struct{} {
+	return g.done
+}
+
+func (g *ConsumerGroup) run(ctx context.Context, beats <-chan amqp.Delivery) {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	g.heartbeat(false)
+	g.rebalance()
+	for {
+		select {
+		case <-ctx.Done():
+			g.heartbeat(true)
+			for shard, c := range g.running {
+				g.release(shard, c)
+			}
+			g.releasing.Wait()
+			g.ch.Close()
+			g.setAssigned(nil)
+			close(g.done)
+			return
+
+		case d, ok := <-beats:
+			if !ok {
+				beats = nil
+				continue
+			}
+			var hb groupHeartbeat
+			if err := json.Unmarshal(d.Body, &hb); err != nil || hb.Member == "" || hb.Member == g.member {
+				continue
+			}
+			_, known := g.members[hb.Member]
+			switch {
+			case hb.Leaving && known:
+				delete(g.members, hb.Member)
+				g.log.Info("group member left", "peer", hb.Member)
+				g.rebalance()
+			case !hb.Leaving && !known:
+				g.members[hb.Member] = time.Now()
+				g.log.Info("group member joined", "peer", hb.Member)
+				g.rebalance()
+			case !hb.Leaving:
+				g.members[hb.Member] = time.Now()
+			}
+
+		case <-ticker.C:
+			g.heartbeat(false)
+			g.members[g.member] = time.Now()
+			for m, seen := range g.members {
+				if time.Since(seen) > 3*g.interval {
+					g.log.Warn("group member timed out", "peer", m)
+					delete(g.members, m)
+				}
+			}
+			g.rebalance()
+		}
+	}
+}
+
+// rebalance starts the consumers of newly assigned shards and releases
+// the ones no longer assigned. Shards that fail to start are retried on
+// the next tick.
+func (g *ConsumerGroup) rebalance() {
+	members := make([]string, 0, len(g.members))
+	for m := range g.members {
+		members = append(members, m)
+	}
+	want := assignShards(g.queue.Shards, members, g.member)
+
+	for shard, c := range g.running {
+		if !slices.Contains(want, shard) {
+			g.release(shard, c)
+		}
+	}
+	for _, shard := range want {
+		if _, ok := g.running[shard]; ok {
+			continue
+		}
+		c, err := g.start(shard)
+		if err != nil {
+			g.log.Error("failed to consume shard", "queue", g.queue.ShardName(shard), "error", err)
+			continue
+		}
+		g.running[shard] = c
+		g.log.Info("consuming shard", "queue", g.queue.ShardName(shard))
+	}
+
+	running := make([]int, 0, len(g.running))
+	for shard := range g.running {
+		running = append(running, shard)
+	}
+	slices.Sort(running)
+	g.setAssigned(running)
+}
+
+// release stops a shard consumer in the background. Stopping waits for
+// the in-flight handlers, and the run loop must keep sending heartbeats
+// meanwhile or the peers would time this member out. Should the shard
+// come back before the old consumer is gone, the new one stands by as
+// single active consumer until the old one cancels.
+func (g *ConsumerGroup) release(shard int, c shardConsumer) {
+	delete(g.running, shard)
+	g.releasing.Add(1)
+	go func() {
+		defer g.releasing.Done()
+		c.stop()
+		g.log.Info("released shard", "queue", g.queue.ShardName(shard))
+	}()
+}
+
+func (g *ConsumerGroup) setAssigned(shards []int) {
+	<-g.assigned
+	g.assigned <- shards
+}
+
+func (g *ConsumerGroup) heartbeat(leaving bool) {
+	err := PublishJSON(g.ch, routing.ExchangePerilGroup, groupKey(g.queue), groupHeartbeat{Member: g.member, Leaving: leaving})
+	if err != nil {
+		g.log.Error("failed to publish heartbeat", "error", err)
+	}
+}
+
+func groupKey(q ShardedQueue) string {
+	return "group." + q.Name
+}
+
+// assignShards returns the shards owned by self. Each shard goes to the
+// member with the highest hash of (member, shard), so every instance
+// computes the same assignment and a membership change only moves the
+// shards of the member that came or went.
+func assignShards(shards int, members []string, self string) []int {
+	var owned []int
+	for shard := 0; shard < shards; shard++ {
+		var best string
+		var bestScore uint64
+		for _, m := range members {
+			if score := rendezvousScore(m, shard); best == "" || score > bestScore || (score == bestScore && m < best) {
+				best, bestScore = m, score
+			}
+		}
+		if best == self {
+			owned = append(owned, shard)
+		}
+	}
+	return owned
+}
+
+// rendezvousScore mixes the member hash with the shard number. FNV alone
+// barely spreads short keys that only differ at the end, so the result
+// goes through the splitmix64 finalizer.
+func rendezvousScore(member string, shard int) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(member))
+	x := h.Sum64() + uint64(shard)*0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
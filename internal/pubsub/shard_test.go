@@ -0,0 +1,223 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestAssignShards(t *testing.T) {
+	tests := []struct {
+		name    string
+		shards  int
+		members []string
+	}{
+		{"no members", 8, nil},
+		{"single member", 8, []string{"a"}},
+		{"two members", 16, []string{"a", "b"}},
+		{"many members", 64, []string{"pod-0", "pod-1", "pod-2", "pod-3", "pod-4"}},
+		{"more members than shards", 2, []string{"a", "b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner := make(map[int]string)
+			for _, m := range tt.members {
+				for _, shard := range assignShards(tt.shards, tt.members, m) {
+					if prev, ok := owner[shard]; ok {
+						t.Fatalf("shard %d owned by %s and %s", shard, prev, m)
+					}
+					owner[shard] = m
+				}
+			}
+			if len(tt.members) > 0 && len(owner) != tt.shards {
+				t.Errorf("%d of %d shards assigned", len(owner), tt.shards)
+			}
+
+			// every member computes the same result whatever order it
+			// learned about its peers in
+			reversed := slices.Clone(tt.members)
+			slices.Reverse(reversed)
+			for _, m := range tt.members {
+				if a, b := assignShards(tt.shards, tt.members, m), assignShards(tt.shards, reversed, m); !slices.Equal(a, b) {
+					t.Errorf("%s: assignment depends on member order: %v vs %v", m, a, b)
+				}
+			}
+		})
+	}
+}
+
+func TestAssignShardsMovesOnlyJoinedShards(t *testing.T) {
+	before := []string{"a", "b", "c"}
+	after := append(slices.Clone(before), "d")
+	for _, m := range before {
+		was := assignShards(64, before, m)
+		for _, shard := range assignShards(64, after, m) {
+			if !slices.Contains(was, shard) {
+				t.Errorf("%s gained shard %d when d joined", m, shard)
+			}
+		}
+	}
+	if len(assignShards(64, after, "d")) == 0 {
+		t.Error("the new member got no shards")
+	}
+}
+
+// fakeGroupChannel records the heartbeats a group publishes
+type fakeGroupChannel struct {
+	mu    sync.Mutex
+	beats []groupHeartbeat
+}
+
+func (c *fakeGroupChannel) PublishWithContext(_ context.Context, _, _ string, _, _ bool, msg amqp.Publishing) error {
+	var hb groupHeartbeat
+	if err := json.Unmarshal(msg.Body, &hb); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.beats = append(c.beats, hb)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *fakeGroupChannel) Close() error { return nil }
+
+func (c *fakeGroupChannel) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.beats)
+}
+
+func (c *fakeGroupChannel) last() groupHeartbeat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.beats[len(c.beats)-1]
+}
+
+// slowConsumer stops only once release is closed, like a consumer
+// waiting for a slow handler
+type slowConsumer struct {
+	release chan struct{}
+	stopped chan struct{}
+}
+
+func (c *slowConsumer) stop() {
+	<-c.release
+	close(c.stopped)
+}
+
+func beat(member string, leaving bool) amqp.Delivery {
+	body, _ := json.Marshal(groupHeartbeat{Member: member, Leaving: leaving})
+	return amqp.Delivery{Body: body}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConsumerGroupRebalance(t *testing.T) {
+	const shards = 16
+	q := ShardedQueue{Name: "orders", Shards: shards}
+	mine := assignShards(shards, []string{"a", "b"}, "a")
+	if len(mine) == shards || len(mine) == 0 {
+		t.Fatalf("test needs a split assignment, a owns %v", mine)
+	}
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	consumers := make(map[int]*slowConsumer)
+	start := func(shard int) (shardConsumer, error) {
+		c := &slowConsumer{release: release, stopped: make(chan struct{})}
+		mu.Lock()
+		consumers[shard] = c
+		mu.Unlock()
+		return c, nil
+	}
+
+	ch := &fakeGroupChannel{}
+	g := newConsumerGroup(q, "a", ch, start, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	g.interval = 20 * time.Millisecond
+	beats := make(chan amqp.Delivery)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.run(ctx, beats)
+
+	waitFor(t, "all shards", func() bool { return len(g.Assigned()) == shards })
+
+	// b joins: a releases b's shards, whose consumers are slow to stop
+	beats <- beat("b", false)
+	waitFor(t, "rebalance", func() bool { return slices.Equal(g.Assigned(), mine) })
+
+	// heartbeats go on while the released consumers drain
+	n := ch.count()
+	waitFor(t, "heartbeats during the drain", func() bool { return ch.count() >= n+3 })
+	mu.Lock()
+	for shard, c := range consumers {
+		select {
+		case <-c.stopped:
+			t.Errorf("shard %d stopped before its handlers drained", shard)
+		default:
+		}
+	}
+	mu.Unlock()
+
+	// b leaves: a takes every shard back, even those still draining
+	beats <- beat("b", true)
+	waitFor(t, "shards back", func() bool { return len(g.Assigned()) == shards })
+
+	close(release)
+	cancel()
+	select {
+	case <-g.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("group did not stop")
+	}
+	if hb := ch.last(); !hb.Leaving || hb.Member != "a" {
+		t.Errorf("last heartbeat = %+v, want a leaving", hb)
+	}
+	if got := g.Assigned(); len(got) != 0 {
+		t.Errorf("Assigned after stop = %v", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for shard, c := range consumers {
+		select {
+		case <-c.stopped:
+		default:
+			t.Errorf("shard %d still running after Done", shard)
+		}
+	}
+}
+
+func TestConsumerGroupExpiresSilentMembers(t *testing.T) {
+	q := ShardedQueue{Name: "orders", Shards: 16}
+	start := func(int) (shardConsumer, error) {
+		c := &slowConsumer{release: make(chan struct{}), stopped: make(chan struct{})}
+		close(c.release)
+		return c, nil
+	}
+	g := newConsumerGroup(q, "a", &fakeGroupChannel{}, start, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	g.interval = 50 * time.Millisecond
+	beats := make(chan amqp.Delivery)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.run(ctx, beats)
+
+	beats <- beat("b", false)
+	waitFor(t, "b's shards released", func() bool { return len(g.Assigned()) < 16 })
+	// b never beats again and times out after three intervals
+	waitFor(t, "b expired", func() bool { return len(g.Assigned()) == 16 })
+}
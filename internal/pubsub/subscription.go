@@ -0,0 +1,228 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/abdooman21/ecom-plat/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrSubscriptionClosed is returned when resuming a closed Subscription
+var ErrSubscriptionClosed = errors.New("subscription closed")
+
+// Subscription is a Subscribe consumer that can be paused and resumed.
+// Pausing cancels the consumer: messages already received are handled,
+// the rest stay in the queue for other instances or for Resume.
+type Subscription struct {
+	Queue string
+
+	start func() (*consumer, error)
+	log   *slog.Logger
+
+	// mu guards the fields below and is never held while a consumer
+	// drains, so Err and Paused answer at once during a slow Pause
+	mu       sync.Mutex
+	current  *consumer     // nil while paused
+	draining chan struct{} // closed once the last paused consumer stopped
+	closed   bool
+}
+
+// NewSubscription starts consuming like Subscribe and returns a handle to
+// pause, resume or close the consumer
+func NewSubscription[T any](
+	conn *amqp.Connection,
+	exchange,
+	queueName,
+	key string,
+	queueType SimpleQueueType,
+	handler func(*T) AckType,
+	unmarshaller func([]byte) (*T, error),
+	opts ...Option,
+) (*Subscription, error) {
+	s := &Subscription{
+		Queue: queueName,
+		log:   newOptions(opts).logger.With(slog.String("queue", queueName)),
+		start: func() (*consumer, error) {
+			return startConsumer(conn, exchange, queueName, key, queueType, withoutContext(handler), bodyDecoder(unmarshaller), opts...)
+		},
+	}
+	c, err := s.start()
+	if err != nil {
+		return nil, err
+	}
+	s.current = c
+	return s, nil
+}
+
+// Pause stops consuming and waits for in-flight messages to be handled.
+// The subscription reports paused as soon as Pause is called. Since it
+// waits for the handler, a handler (or a callback it runs, like
+// CircuitBreaker.OnStateChange) must call it as `go sub.Pause()`.
+func (s *Subscription) Pause() {
+	s.mu.Lock()
+	c := s.current
+	if c == nil {
+		// already paused, maybe still draining
+		draining := s.draining
+		s.mu.Unlock()
+		if draining != nil {
+			<-draining
+		}
+		return
+	}
+	s.current = nil
+	draining := make(chan struct{})
+	s.draining = draining
+	s.mu.Unlock()
+
+	c.stop()
+	close(draining)
+	s.log.Info("paused consuming")
+}
+
+// Resume starts consuming again after Pause
+func (s *Subscription) Resume() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSubscriptionClosed
+	}
+	if s.current != nil {
+		return nil
+	}
+	c, err := s.start()
+	if err != nil {
+		return fmt.Errorf("failed to resume %s: %w", s.Queue, err)
+	}
+	s.current = c
+	s.log.Info("resumed consuming")
+	return nil
+}
+
+// Err reports why the subscription is not consuming when it should be:
+// its channel was closed, or the broker cancelled the consumer (e.g. the
+// queue was deleted). A paused subscription is healthy.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	c, closed := s.current, s.closed
+	s.mu.Unlock()
+	switch {
+	case closed:
+		return ErrSubscriptionClosed
+	case c == nil:
+		return nil
+	case c.ch.IsClosed():
+		return fmt.Errorf("%s: channel closed", s.Queue)
+	}
+	select {
+	case <-c.done:
+		return fmt.Errorf("%s: consumer cancelled by the broker", s.Queue)
+	default:
+		return nil
+	}
+}
+
+// Paused reports whether the subscription is currently not consuming
+func (s *Subscription) Paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current == nil
+}
+
+// Close stops consuming for good. It is marked closed before pausing so
+// a concurrent Resume cannot restart the consumer.
+func (s *Subscription) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.Pause()
+}
+
+// ControlCommand is broadcast on the control exchange to every instance
+type ControlCommand struct {
+	Action string `json:"action"` // "pause" or "resume"
+	Queue  string `json:"queue"`  // subscriptions of this queue, or all when empty
+}
+
+const (
+	ControlPause  = "pause"
+	ControlResume = "resume"
+)
+
+// ControlListener applies broadcast ControlCommands to the subscriptions
+// registered with it, so an operator can pause every consumer of a queue
+// across all instances at once
+type ControlListener struct {
+	mu   sync.Mutex
+	subs []*Subscription
+}
+
+// ListenControl declares the control exchange and starts applying the
+// commands it receives to subs. Each instance gets its own transient
+// queue, so every instance sees every command.
+func ListenControl(conn *amqp.Connection, subs ...*Subscription) (*ControlListener, error) {
+	if err := declareControlExchange(conn); err != nil {
+		return nil, err
+	}
+	l := &ControlListener{subs: subs}
+	queueName := "peril_control." + newCorrelationID()[:12]
+	err := Subscribe(conn, routing.ExchangePerilControl, queueName, "", Transient, l.handle, JSONUnmarshaller[ControlCommand])
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for control commands: %w", err)
+	}
+	return l, nil
+}
+
+// Add registers more subscriptions with the listener
+func (l *ControlListener) Add(subs ...*Subscription) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.subs = append(l.subs, subs...)
+}
+
+func (l *ControlListener) handle(cmd *ControlCommand) AckType {
+	l.mu.Lock()
+	subs := append([]*Subscription(nil), l.subs...)
+	l.mu.Unlock()
+
+	for _, s := range subs {
+		if cmd.Queue != "" && cmd.Queue != s.Queue {
+			continue
+		}
+		switch cmd.Action {
+		case ControlPause:
+			s.Pause()
+		case ControlResume:
+			if err := s.Resume(); err != nil {
+				logger().Error("control command failed", "action", cmd.Action, "error", err)
+			}
+		default:
+			logger().Warn("unknown control action", "action", cmd.Action)
+			return Discard
+		}
+	}
+	return Ack
+}
+
+// SendControl broadcasts cmd to the ControlListeners of all instances
+func SendControl(conn *amqp.Connection, cmd ControlCommand) error {
+	if err := declareControlExchange(conn); err != nil {
+		return err
+	}
+	return PublishJSON(PoolFor(conn), routing.ExchangePerilControl, "", cmd)
+}
+
+func declareControlExchange(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer ch.Close()
+	if err := ch.ExchangeDeclare(routing.ExchangePerilControl, "fanout", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare control exchange: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package pubsub
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsumerChannel stands for the channel of a consumer whose
+// deliveries finish once release is closed
+type fakeConsumerChannel struct {
+	done    chan struct{}
+	release chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func (c *fakeConsumerChannel) Cancel(string, bool) error {
+	go func() {
+		<-c.release
+		close(c.done)
+	}()
+	return nil
+}
+
+func (c *fakeConsumerChannel) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *fakeConsumerChannel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func newTestSubscription(release chan struct{}) (*Subscription, func() []*consumer) {
+	var mu sync.Mutex
+	var started []*consumer
+	s := &Subscription{
+		Queue: "orders",
+		log:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		start: func() (*consumer, error) {
+			done := make(chan struct{})
+			c := &consumer{ch: &fakeConsumerChannel{done: done, release: release}, tag: "ctag", done: done}
+			mu.Lock()
+			started = append(started, c)
+			mu.Unlock()
+			return c, nil
+		},
+	}
+	s.current, _ = s.start()
+	return s, func() []*consumer {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*consumer(nil), started...)
+	}
+}
+
+func TestSubscriptionPauseDoesNotBlockErr(t *testing.T) {
+	release := make(chan struct{})
+	s, _ := newTestSubscription(release)
+
+	paused := make(chan struct{})
+	go func() {
+		s.Pause()
+		close(paused)
+	}()
+	waitFor(t, "Paused", s.Paused)
+
+	// the handlers are still draining: Err and Paused must not wait
+	answered := make(chan error)
+	go func() { answered <- s.Err() }()
+	select {
+	case err := <-answered:
+		if err != nil {
+			t.Errorf("Err while draining = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Err blocked on a draining Pause")
+	}
+
+	// a second Pause waits for the drain too
+	second := make(chan struct{})
+	go func() {
+		s.Pause()
+		close(second)
+	}()
+	select {
+	case <-paused:
+		t.Fatal("Pause returned before the handlers drained")
+	case <-second:
+		t.Fatal("second Pause returned before the handlers drained")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-paused
+	<-second
+}
+
+func TestSubscriptionErr(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(s *Subscription, c *consumer)
+		want  string
+	}{
+		{"running", func(*Subscription, *consumer) {}, ""},
+		{"paused", func(s *Subscription, _ *consumer) { s.Pause() }, ""},
+		{"channel closed", func(_ *Subscription, c *consumer) { c.ch.Close() }, "orders: channel closed"},
+		{"consumer cancelled", func(_ *Subscription, c *consumer) { close(c.done) }, "orders: consumer cancelled by the broker"},
+		{"closed", func(s *Subscription, _ *consumer) { s.Close() }, ErrSubscriptionClosed.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			close(release)
+			s, started := newTestSubscription(release)
+			tt.setup(s, started()[0])
+			got := ""
+			if err := s.Err(); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("Err = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionResume(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	s, started := newTestSubscription(release)
+
+	if err := s.Resume(); err != nil || len(started()) != 1 {
+		t.Fatalf("Resume while running = %v, started %d consumers", err, len(started()))
+	}
+	s.Pause()
+	if err := s.Resume(); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if s.Paused() || len(started()) != 2 {
+		t.Fatalf("Resume did not start a new consumer")
+	}
+	s.Close()
+	if err := s.Resume(); !errors.Is(err, ErrSubscriptionClosed) {
+		t.Errorf("Resume after Close = %v, want %v", err, ErrSubscriptionClosed)
+	}
+	if !started()[1].ch.IsClosed() {
+		t.Error("Close left the consumer's channel open")
+	}
+}
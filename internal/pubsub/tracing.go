@@ -0,0 +1,70 @@
+package pubsub
+
+import (
+	"context"
+
+	"github.com/abdooman21/ecom-plat/internal/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// startPublishSpan starts the producer span of msg and injects its W3C
+// traceparent into the message headers, so the consumer's span joins the
+// trace of whatever published it (e.g. the HTTP request creating an order)
+func startPublishSpan(ctx context.Context, exchange, key string, msg *amqp.Publishing) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, "publish "+destination(exchange), tracing.KindProducer,
+		tracing.String("messaging.system", "rabbitmq"),
+		tracing.String("messaging.operation.type", "send"),
+		tracing.String("messaging.operation.name", "publish"),
+		tracing.String("messaging.destination.name", destination(exchange)),
+		tracing.String("messaging.rabbitmq.destination.routing_key", key),
+		tracing.Int("messaging.message.body.size", len(msg.Body)),
+	)
+	if msg.MessageId != "" {
+		span.SetAttributes(tracing.String("messaging.message.id", msg.MessageId))
+	}
+	if msg.CorrelationId != "" {
+		span.SetAttributes(tracing.String("messaging.message.conversation_id", msg.CorrelationId))
+	}
+
+	// copy the table, an option may have set one shared between messages
+	headers := make(amqp.Table, len(msg.Headers)+2)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	tracing.Inject(ctx, tracing.TableCarrier(headers))
+	msg.Headers = headers
+	return ctx, span
+}
+
+// startConsumeSpan starts the consumer span of d as a child of the
+// producer span found in its headers
+func startConsumeSpan(ctx context.Context, d amqp.Delivery, queueName string) (context.Context, *tracing.Span) {
+	if d.Headers != nil {
+		ctx = tracing.Extract(ctx, tracing.TableCarrier(d.Headers))
+	}
+	ctx, span := tracing.Start(ctx, "process "+queueName, tracing.KindConsumer,
+		tracing.String("messaging.system", "rabbitmq"),
+		tracing.String("messaging.operation.type", "process"),
+		tracing.String("messaging.operation.name", "process"),
+		tracing.String("messaging.destination.name", destination(d.Exchange)),
+		tracing.String("messaging.destination.subscription.name", queueName),
+		tracing.String("messaging.rabbitmq.destination.routing_key", d.RoutingKey),
+		tracing.Int("messaging.message.body.size", len(d.Body)),
+		tracing.Bool("messaging.rabbitmq.redelivered", d.Redelivered),
+	)
+	if d.MessageId != "" {
+		span.SetAttributes(tracing.String("messaging.message.id", d.MessageId))
+	}
+	if d.CorrelationId != "" {
+		span.SetAttributes(tracing.String("messaging.message.conversation_id", d.CorrelationId))
+	}
+	return ctx, span
+}
+
+// destination names the default exchange the way the broker does
+func destination(exchange string) string {
+	if exchange == "" {
+		return "amq.default"
+	}
+	return exchange
+}
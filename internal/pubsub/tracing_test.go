@@ -0,0 +1,50 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/abdooman21/ecom-plat/internal/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestConsumeSpanContinuesIntoPublishes(t *testing.T) {
+	// a message published with a trace...
+	_, producer := tracing.Start(context.Background(), "checkout", tracing.KindServer)
+	msg := amqp.Publishing{Body: []byte("{}")}
+	pubCtx := tracing.ContextWithSpan(context.Background(), producer)
+	_, publishSpan := startPublishSpan(pubCtx, "peril_topic", "order.eu.ORD-1", &msg)
+
+	// ...is consumed, and the handler publishes with the ctx it got
+	d := amqp.Delivery{Exchange: "peril_topic", RoutingKey: "order.eu.ORD-1", Headers: msg.Headers, Body: msg.Body}
+	handlerCtx, consumeSpan := startConsumeSpan(context.Background(), d, "orders_queue")
+	next := amqp.Publishing{}
+	_, nextSpan := startPublishSpan(handlerCtx, "", "shipping_queue", &next)
+
+	if consumeSpan.Parent != publishSpan.Context.SpanID {
+		t.Errorf("consume span parent = %s, want the publish span %s", consumeSpan.Parent, publishSpan.Context.SpanID)
+	}
+	if nextSpan.Parent != consumeSpan.Context.SpanID {
+		t.Errorf("handler publish parent = %s, want the consume span %s", nextSpan.Parent, consumeSpan.Context.SpanID)
+	}
+	for _, span := range []*tracing.Span{publishSpan, consumeSpan, nextSpan} {
+		if span.Context.TraceID != producer.Context.TraceID {
+			t.Errorf("%s left the trace: %s, want %s", span.Name, span.Context.TraceID, producer.Context.TraceID)
+		}
+	}
+	if tp := tracing.TableCarrier(next.Headers).Get(tracing.TraceparentHeader); tp != tracing.FormatTraceparent(nextSpan.Context) {
+		t.Errorf("traceparent = %q, want the handler publish span", tp)
+	}
+}
+
+func TestWithoutContext(t *testing.T) {
+	got := 0
+	h := withoutContext(func(n *int) AckType {
+		got = *n
+		return Requeue
+	})
+	n := 7
+	if ack := h(context.Background(), &n); ack != Requeue || got != 7 {
+		t.Errorf("wrapped handler returned %v and saw %d", ack, got)
+	}
+}
@@ -1,10 +1,15 @@
 // internal/routing/keys.go
 package routing
 
+import "strings"
+
 const (
 	// Exchanges
-	ExchangePerilTopic = "peril_topic"
-	ExchangePerilDLX   = "peril_dlx" // Dead Letter Exchange
+	ExchangePerilTopic   = "peril_topic"
+	ExchangePerilDLX     = "peril_dlx"     // Dead Letter Exchange
+	ExchangePerilRPC     = "peril_rpc"     // Direct exchange for request/reply calls
+	ExchangePerilGroup   = "peril_groups"  // Topic exchange for consumer group heartbeats
+	ExchangePerilControl = "peril_control" // Fanout exchange for pause/resume commands
 
 	// Main Queue Configuration
 	Prod_Queue = "orders_queue"
@@ -22,6 +27,10 @@ const (
 	HighPriorityKey = "order.*.urgent" // High priority orders
 	DeadLetterQueue = "dead_letter_queue"
 	RetryQueue      = "retry_queue"
+
+	// RPC Queues and Keys
+	StockCheckQueue = "stock_check_queue"
+	StockCheckKey   = "rpc.stock.check"
 )
 
 // RoutingConfig holds routing configuration for a queue
@@ -79,3 +88,62 @@ func ValidateRoutingKey(key string) bool {
 func BuildRoutingKey(region, orderID string) string {
 	return "order." + region + "." + orderID
 }
+
+// Message priorities used with queues declared with x-max-priority
+const (
+	PriorityNormal uint8 = 0
+	PriorityUrgent uint8 = 9
+	MaxPriority          = PriorityUrgent
+)
+
+// PriorityForKey derives a message priority from its routing key:
+// keys matching HighPriorityKey are urgent, everything else is normal
+func PriorityForKey(key string) uint8 {
+	if MatchKey(HighPriorityKey, key) {
+		return PriorityUrgent
+	}
+	return PriorityNormal
+}
+
+// MatchKey reports whether a routing key matches a topic binding pattern,
+// where '*' matches exactly one word and '#' matches zero or more words.
+// Like the broker, it treats an empty key as having no words at all.
+func MatchKey(pattern, key string) bool {
+	return matchWords(splitWords(pattern), splitWords(key))
+}
+
+func splitWords(key string) []string {
+	if key == "" {
+		return nil
+	}
+	return strings.Split(key, ".")
+}
+
+func matchWords(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if matchWords(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && matchWords(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && matchWords(pattern[1:], words[1:])
+	}
+}
+
+// OrderIDFromKey returns the order id of an order.{region}.{orderID}
+// routing key, or the whole key when it does not follow that layout
+func OrderIDFromKey(key string) string {
+	parts := strings.SplitN(key, ".", 3)
+	if len(parts) != 3 || parts[0] != "order" {
+		return key
+	}
+	return parts[2]
+}
@@ -0,0 +1,67 @@
+package routing
+
+import "testing"
+
+func TestMatchKey(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"order.us.ORD-1", "order.us.ORD-1", true},
+		{"order.us.ORD-1", "order.eu.ORD-1", false},
+
+		// '*' matches exactly one word
+		{"order.*.*", "order.us.ORD-1", true},
+		{"order.*.*", "order.us", false},
+		{"order.*.*", "order.us.ORD-1.extra", false},
+		{"*.*.eu", "order.1.eu", true},
+		{"*", "order", true},
+		{"*", "order.us", false},
+
+		// '#' matches zero or more words
+		{"#", "order.us.ORD-1", true},
+		{"order.#", "order", true},
+		{"order.#", "order.us.ORD-1", true},
+		{"order.#", "orders.us", false},
+		{"#.urgent", "urgent", true},
+		{"#.urgent", "order.us.urgent", true},
+
+		// '#' in the middle backtracks
+		{"order.#.urgent", "order.urgent", true},
+		{"order.#.urgent", "order.us.urgent", true},
+		{"order.#.urgent", "order.us.eu.urgent", true},
+		{"order.#.urgent", "order.us.urgent.late", false},
+		{"order.#.*.urgent", "order.urgent", false},
+		{"order.#.*.urgent", "order.us.urgent", true},
+		{"#.order.#", "a.b.order.c", true},
+
+		// an empty key has no words
+		{"#", "", true},
+		{"*", "", false},
+		{"", "", true},
+		{"", "order", false},
+		{"order.#", "", false},
+	}
+	for _, tt := range tests {
+		if got := MatchKey(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("MatchKey(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestPriorityForKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want uint8
+	}{
+		{"order.us.urgent", PriorityUrgent},
+		{"order.eu.ORD-1", PriorityNormal},
+		{"order.us.urgent.late", PriorityNormal},
+		{"", PriorityNormal},
+	}
+	for _, tt := range tests {
+		if got := PriorityForKey(tt.key); got != tt.want {
+			t.Errorf("PriorityForKey(%q) = %d, want %d", tt.key, got, tt.want)
+		}
+	}
+}
@@ -12,7 +12,7 @@ func PrintServerHelp() {
 	fmt.Println("Possible commands:")
 	fmt.Println("* pause")
 	fmt.Println("* resume")
-	fmt.Print("add <region> <prodID> <ProdName> <price>")
+	fmt.Println("* add <region> <prodID> <ProdName> <price>")
 	fmt.Println("* quit")
 	fmt.Println("* help")
 }
@@ -36,9 +36,15 @@ func ClientWelcome() (string, error) {
 	return username, nil
 }
 
+// stdin is shared by every GetInput call: a scanner reads ahead, so a new
+// one per call would drop the lines buffered by the previous one
+var stdin = bufio.NewScanner(os.Stdin)
+
+// GetInput prompts for a line and returns its words. It returns nil once
+// stdin is closed, and an empty slice for a blank line.
 func GetInput() []string {
 	fmt.Print("> ")
-	scanner := bufio.NewScanner(os.Stdin)
+	scanner := stdin
 	scanned := scanner.Scan()
 	if !scanned {
 		return nil
@@ -0,0 +1,246 @@
+// internal/topology/diff.go
+package topology
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// DriftKind classifies a difference between the spec and the broker
+type DriftKind string
+
+const (
+	Missing    DriftKind = "missing"    // declared in the spec, absent on the broker
+	Extra      DriftKind = "extra"      // present on the broker, absent from the spec
+	Mismatched DriftKind = "mismatched" // present on both with different properties
+)
+
+// Drift is a single difference found by Diff
+type Drift struct {
+	Kind     DriftKind
+	Resource string // "exchange", "queue" or "binding"
+	Name     string
+	Details  []string
+}
+
+func (d Drift) String() string {
+	s := fmt.Sprintf("%s %s %s", d.Kind, d.Resource, d.Name)
+	if len(d.Details) > 0 {
+		s += ": " + strings.Join(d.Details, "; ")
+	}
+	return s
+}
+
+// Report is the outcome of comparing a Spec with the broker state
+type Report struct {
+	Drifts []Drift
+}
+
+// HasDrift reports whether any difference was found
+func (r Report) HasDrift() bool {
+	return len(r.Drifts) > 0
+}
+
+// Count returns how many drifts of the given kind were found
+func (r Report) Count(kind DriftKind) int {
+	n := 0
+	for _, d := range r.Drifts {
+		if d.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// Diff compares the expected topology with the actual one. Broker-owned
+// objects (the default exchange, amq.* exchanges and the implicit
+// default-exchange bindings) are never reported as extra.
+func Diff(expected, actual Spec) Report {
+	var r Report
+	r.Drifts = append(r.Drifts, diffExchanges(expected.Exchanges, actual.Exchanges)...)
+	r.Drifts = append(r.Drifts, diffQueues(expected.Queues, actual.Queues)...)
+	r.Drifts = append(r.Drifts, diffBindings(expected.Bindings, actual.Bindings)...)
+	return r
+}
+
+func diffExchanges(expected, actual []Exchange) []Drift {
+	var drifts []Drift
+	have := make(map[string]Exchange, len(actual))
+	for _, e := range actual {
+		have[e.Name] = e
+	}
+	want := make(map[string]bool, len(expected))
+
+	for _, e := range expected {
+		want[e.Name] = true
+		got, ok := have[e.Name]
+		if !ok {
+			if !e.Optional {
+				drifts = append(drifts, Drift{Kind: Missing, Resource: "exchange", Name: e.Name})
+			}
+			continue
+		}
+		var details []string
+		if e.Type != "" && e.Type != got.Type {
+			details = append(details, fmt.Sprintf("type: want %s, got %s", e.Type, got.Type))
+		}
+		details = append(details, compareFlag("durable", e.Durable, got.Durable)...)
+		details = append(details, compareFlag("auto_delete", e.AutoDelete, got.AutoDelete)...)
+		details = append(details, compareFlag("internal", e.Internal, got.Internal)...)
+		details = append(details, compareArgs(e.Arguments, got.Arguments)...)
+		if len(details) > 0 {
+			drifts = append(drifts, Drift{Kind: Mismatched, Resource: "exchange", Name: e.Name, Details: details})
+		}
+	}
+
+	for _, e := range actual {
+		if want[e.Name] || e.Name == "" || strings.HasPrefix(e.Name, "amq.") {
+			continue
+		}
+		drifts = append(drifts, Drift{Kind: Extra, Resource: "exchange", Name: e.Name})
+	}
+	return drifts
+}
+
+func diffQueues(expected, actual []Queue) []Drift {
+	var drifts []Drift
+	have := make(map[string]Queue, len(actual))
+	for _, q := range actual {
+		have[q.Name] = q
+	}
+	want := make(map[string]bool, len(expected))
+
+	for _, q := range expected {
+		want[q.Name] = true
+		got, ok := have[q.Name]
+		if !ok {
+			if !q.Optional {
+				drifts = append(drifts, Drift{Kind: Missing, Resource: "queue", Name: q.Name})
+			}
+			continue
+		}
+		var details []string
+		details = append(details, compareFlag("durable", q.Durable, got.Durable)...)
+		details = append(details, compareFlag("auto_delete", q.AutoDelete, got.AutoDelete)...)
+		details = append(details, compareArgs(q.Arguments, got.Arguments)...)
+		if len(details) > 0 {
+			drifts = append(drifts, Drift{Kind: Mismatched, Resource: "queue", Name: q.Name, Details: details})
+		}
+	}
+
+	for _, q := range actual {
+		if !want[q.Name] {
+			drifts = append(drifts, Drift{Kind: Extra, Resource: "queue", Name: q.Name})
+		}
+	}
+	return drifts
+}
+
+func diffBindings(expected, actual []Binding) []Drift {
+	var drifts []Drift
+	have := make(map[string]Binding, len(actual))
+	for _, b := range actual {
+		have[bindingKey(b)] = b
+	}
+	want := make(map[string]bool, len(expected))
+
+	for _, b := range expected {
+		key := bindingKey(b)
+		want[key] = true
+		got, ok := have[key]
+		if !ok {
+			if !b.Optional {
+				drifts = append(drifts, Drift{Kind: Missing, Resource: "binding", Name: key})
+			}
+			continue
+		}
+		if details := compareArgs(b.Arguments, got.Arguments); len(details) > 0 {
+			drifts = append(drifts, Drift{Kind: Mismatched, Resource: "binding", Name: key, Details: details})
+		}
+	}
+
+	for _, b := range actual {
+		key := bindingKey(b)
+		if want[key] || b.Source == "" {
+			continue
+		}
+		drifts = append(drifts, Drift{Kind: Extra, Resource: "binding", Name: key})
+	}
+	return drifts
+}
+
+// bindingKey identifies a binding as "source -[key]-> destination"
+func bindingKey(b Binding) string {
+	dest := b.Destination
+	if b.DestinationType == "exchange" {
+		dest = "exchange:" + dest
+	}
+	return fmt.Sprintf("%s -[%s]-> %s", b.Source, b.RoutingKey, dest)
+}
+
+func compareFlag(name string, want, got bool) []string {
+	if want == got {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s: want %t, got %t", name, want, got)}
+}
+
+// compareArgs compares argument tables. Numbers are compared by value so
+// that an int in a Go spec matches the float64 decoded from JSON.
+func compareArgs(want, got map[string]any) []string {
+	keys := make(map[string]bool)
+	for k := range want {
+		keys[k] = true
+	}
+	for k := range got {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var details []string
+	for _, k := range sorted {
+		w, wok := want[k]
+		g, gok := got[k]
+		switch {
+		case !gok:
+			details = append(details, fmt.Sprintf("argument %s: want %v, not set", k, w))
+		case !wok:
+			details = append(details, fmt.Sprintf("argument %s: not expected, got %v", k, g))
+		case !reflect.DeepEqual(normalize(w), normalize(g)):
+			details = append(details, fmt.Sprintf("argument %s: want %v, got %v", k, w, g))
+		}
+	}
+	return details
+}
+
+func normalize(v any) any {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int8:
+		return float64(n)
+	case int16:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case uint8:
+		return float64(n)
+	case uint16:
+		return float64(n)
+	case uint32:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	}
+	return v
+}
@@ -0,0 +1,155 @@
+// internal/topology/topology.go
+package topology
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/abdooman21/ecom-plat/internal/management"
+	"github.com/abdooman21/ecom-plat/internal/routing"
+)
+
+// Spec describes the exchanges, queues and bindings a deployment expects
+// the broker to have
+type Spec struct {
+	Exchanges []Exchange `json:"exchanges"`
+	Queues    []Queue    `json:"queues"`
+	Bindings  []Binding  `json:"bindings"`
+}
+
+// Exchange, Queue and Binding describe one expected entity. An Optional
+// entity is only compared when the broker has it, and an empty exchange
+// Type matches any type.
+type Exchange struct {
+	Name       string         `json:"name"`
+	Type       string         `json:"type"`
+	Durable    bool           `json:"durable"`
+	AutoDelete bool           `json:"auto_delete"`
+	Internal   bool           `json:"internal"`
+	Arguments  map[string]any `json:"arguments,omitempty"`
+	Optional   bool           `json:"optional,omitempty"`
+}
+
+type Queue struct {
+	Name       string         `json:"name"`
+	Durable    bool           `json:"durable"`
+	AutoDelete bool           `json:"auto_delete"`
+	Arguments  map[string]any `json:"arguments,omitempty"`
+	Optional   bool           `json:"optional,omitempty"`
+}
+
+type Binding struct {
+	Source          string         `json:"source"`
+	Destination     string         `json:"destination"`
+	DestinationType string         `json:"destination_type"` // "queue" or "exchange"
+	RoutingKey      string         `json:"routing_key"`
+	Arguments       map[string]any `json:"arguments,omitempty"`
+	Optional        bool           `json:"optional,omitempty"`
+}
+
+// Standard returns the topology the pubsub helpers declare for the
+// standard routing configs. The queues dead-letter to peril_dlx, but no
+// code here declares it, its queue or its binding, so those are optional
+// and the exchange type is not checked.
+func Standard() Spec {
+	spec := Spec{
+		Exchanges: []Exchange{
+			{Name: routing.ExchangePerilTopic, Type: "topic", Durable: true},
+			{Name: routing.ExchangePerilDLX, Durable: true, Optional: true},
+		},
+		Queues: []Queue{
+			{Name: routing.DeadLetterQueue, Durable: true, Optional: true},
+		},
+		Bindings: []Binding{
+			{Source: routing.ExchangePerilDLX, Destination: routing.DeadLetterQueue, DestinationType: "queue", Optional: true},
+		},
+	}
+
+	for _, rc := range routing.GetStandardRoutingConfigs() {
+		spec.Queues = append(spec.Queues, Queue{
+			Name:       rc.QueueName,
+			Durable:    rc.Durable,
+			AutoDelete: !rc.Durable,
+			Arguments:  map[string]any{"x-dead-letter-exchange": routing.ExchangePerilDLX},
+		})
+		spec.Bindings = append(spec.Bindings, Binding{
+			Source:          rc.Exchange,
+			Destination:     rc.QueueName,
+			DestinationType: "queue",
+			RoutingKey:      rc.RoutingKey,
+		})
+	}
+	return spec
+}
+
+// LoadFile reads a Spec from a JSON file
+func LoadFile(path string) (Spec, error) {
+	var spec Spec
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return spec, fmt.Errorf("failed to read topology spec: %w", err)
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return spec, fmt.Errorf("failed to parse topology spec %s: %w", path, err)
+	}
+	for i := range spec.Bindings {
+		if spec.Bindings[i].DestinationType == "" {
+			spec.Bindings[i].DestinationType = "queue"
+		}
+	}
+	return spec, nil
+}
+
+// Fetch reads the actual topology of a vhost through the management API.
+// Exclusive queues are skipped since they belong to a single connection.
+func Fetch(ctx context.Context, c *management.Client, vhost string) (Spec, error) {
+	var spec Spec
+
+	exchanges, err := c.Exchanges(ctx, vhost)
+	if err != nil {
+		return spec, err
+	}
+	for _, e := range exchanges {
+		spec.Exchanges = append(spec.Exchanges, Exchange{
+			Name:       e.Name,
+			Type:       e.Type,
+			Durable:    e.Durable,
+			AutoDelete: e.AutoDelete,
+			Internal:   e.Internal,
+			Arguments:  e.Arguments,
+		})
+	}
+
+	queues, err := c.Queues(ctx, vhost)
+	if err != nil {
+		return spec, err
+	}
+	for _, q := range queues {
+		if q.Exclusive {
+			continue
+		}
+		spec.Queues = append(spec.Queues, Queue{
+			Name:       q.Name,
+			Durable:    q.Durable,
+			AutoDelete: q.AutoDelete,
+			Arguments:  q.Arguments,
+		})
+	}
+
+	bindings, err := c.Bindings(ctx, vhost)
+	if err != nil {
+		return spec, err
+	}
+	for _, b := range bindings {
+		spec.Bindings = append(spec.Bindings, Binding{
+			Source:          b.Source,
+			Destination:     b.Destination,
+			DestinationType: b.DestinationType,
+			RoutingKey:      b.RoutingKey,
+			Arguments:       b.Arguments,
+		})
+	}
+	return spec, nil
+}
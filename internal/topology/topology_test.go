@@ -0,0 +1,181 @@
+package topology
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/abdooman21/ecom-plat/internal/management"
+)
+
+// brokerState is what the stub management API serves for vhost "/"
+var brokerState = map[string]string{
+	"/api/exchanges/%2F": `[
+		{"name": "", "type": "direct", "durable": true},
+		{"name": "amq.topic", "type": "topic", "durable": true},
+		{"name": "peril_topic", "type": "direct", "durable": true},
+		{"name": "stray_exchange", "type": "fanout", "durable": true}
+	]`,
+	"/api/queues/%2F": `[
+		{"name": "orders_queue", "durable": true, "arguments": {"x-dead-letter-exchange": "peril_dlx"}},
+		{"name": "eu_orders_queue", "durable": false, "auto_delete": true, "arguments": {"x-dead-letter-exchange": "peril_dlx"}},
+		{"name": "stray_queue", "durable": true},
+		{"name": "amq.gen-123", "durable": false, "exclusive": true}
+	]`,
+	"/api/bindings/%2F": `[
+		{"source": "", "destination": "orders_queue", "destination_type": "queue", "routing_key": "orders_queue"},
+		{"source": "peril_topic", "destination": "orders_queue", "destination_type": "queue", "routing_key": "order.*.*"},
+		{"source": "peril_topic", "destination": "stray_queue", "destination_type": "queue", "routing_key": "stray.#"}
+	]`,
+}
+
+func newStubBroker(t *testing.T) *management.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "guest" || pass != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, ok := brokerState[r.URL.EscapedPath()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := management.New("http://guest:secret@" + srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return c
+}
+
+func TestFetchAndDiffStandard(t *testing.T) {
+	actual, err := Fetch(context.Background(), newStubBroker(t), "/")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if slices.ContainsFunc(actual.Queues, func(q Queue) bool { return q.Name == "amq.gen-123" }) {
+		t.Errorf("Fetch kept the exclusive queue")
+	}
+
+	report := Diff(Standard(), actual)
+
+	var got []string
+	for _, d := range report.Drifts {
+		got = append(got, string(d.Kind)+" "+d.Resource+" "+d.Name)
+	}
+	want := []string{
+		"mismatched exchange peril_topic",
+		"extra exchange stray_exchange",
+		"mismatched queue eu_orders_queue",
+		"missing queue us_orders_queue",
+		"missing queue analytics_queue",
+		"extra queue stray_queue",
+		"missing binding peril_topic -[order.eu.*]-> eu_orders_queue",
+		"missing binding peril_topic -[order.us.*]-> us_orders_queue",
+		"missing binding peril_topic -[#]-> analytics_queue",
+		"extra binding peril_topic -[stray.#]-> stray_queue",
+	}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("drifts:\n got %q\nwant %q", got, want)
+	}
+
+	for _, d := range report.Drifts {
+		switch d.Name {
+		case "peril_topic":
+			if !slices.Equal(d.Details, []string{"type: want topic, got direct"}) {
+				t.Errorf("peril_topic details = %q", d.Details)
+			}
+		case "eu_orders_queue":
+			if !slices.Equal(d.Details, []string{"durable: want true, got false", "auto_delete: want false, got true"}) {
+				t.Errorf("eu_orders_queue details = %q", d.Details)
+			}
+		}
+	}
+	if report.Count(Missing) != 5 || report.Count(Extra) != 3 || report.Count(Mismatched) != 2 {
+		t.Errorf("counts: missing=%d extra=%d mismatched=%d",
+			report.Count(Missing), report.Count(Extra), report.Count(Mismatched))
+	}
+}
+
+func TestFetchError(t *testing.T) {
+	c := newStubBroker(t)
+	c.Password = "wrong"
+	if _, err := Fetch(context.Background(), c, "/"); err == nil {
+		t.Fatal("Fetch with bad credentials succeeded")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected Spec
+		actual   Spec
+		want     []string
+	}{
+		{
+			name:     "match",
+			expected: Spec{Exchanges: []Exchange{{Name: "x", Type: "topic", Durable: true}}},
+			actual:   Spec{Exchanges: []Exchange{{Name: "x", Type: "topic", Durable: true}}},
+		},
+		{
+			name:     "optional missing is not drift",
+			expected: Spec{Exchanges: []Exchange{{Name: "dlx", Optional: true}}, Queues: []Queue{{Name: "dlq", Optional: true}}},
+		},
+		{
+			name:     "optional present is compared",
+			expected: Spec{Queues: []Queue{{Name: "dlq", Durable: true, Optional: true}}},
+			actual:   Spec{Queues: []Queue{{Name: "dlq"}}},
+			want:     []string{"mismatched queue dlq: durable: want true, got false"},
+		},
+		{
+			name:     "empty type matches any type",
+			expected: Spec{Exchanges: []Exchange{{Name: "dlx", Durable: true}}},
+			actual:   Spec{Exchanges: []Exchange{{Name: "dlx", Type: "headers", Durable: true}}},
+		},
+		{
+			name:     "numeric arguments compare by value",
+			expected: Spec{Queues: []Queue{{Name: "q", Arguments: map[string]any{"x-max-length": 100}}}},
+			actual:   Spec{Queues: []Queue{{Name: "q", Arguments: map[string]any{"x-max-length": float64(100)}}}},
+		},
+		{
+			name:     "argument differences",
+			expected: Spec{Queues: []Queue{{Name: "q", Arguments: map[string]any{"x-max-length": 100, "x-queue-type": "quorum"}}}},
+			actual:   Spec{Queues: []Queue{{Name: "q", Arguments: map[string]any{"x-max-length": 50, "x-message-ttl": 10}}}},
+			want: []string{"mismatched queue q: argument x-max-length: want 100, got 50; " +
+				"argument x-message-ttl: not expected, got 10; argument x-queue-type: want quorum, not set"},
+		},
+		{
+			name: "broker-owned entities are not extra",
+			actual: Spec{
+				Exchanges: []Exchange{{Name: ""}, {Name: "amq.direct"}},
+				Bindings:  []Binding{{Source: "", Destination: "q", DestinationType: "queue", RoutingKey: "q"}},
+			},
+		},
+		{
+			name:     "exchange-to-exchange binding",
+			expected: Spec{Bindings: []Binding{{Source: "a", Destination: "b", DestinationType: "exchange", RoutingKey: "k"}}},
+			want:     []string{"missing binding a -[k]-> exchange:b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, d := range Diff(tt.expected, tt.actual).Drifts {
+				got = append(got, d.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Diff:\n got %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+// internal/tracing/export.go
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Exporter receives ended, sampled spans
+type Exporter interface {
+	Export(span *Span)
+	// Shutdown flushes buffered spans
+	Shutdown(ctx context.Context) error
+}
+
+var (
+	exporterMu sync.RWMutex
+	exporter   Exporter
+)
+
+// SetExporter installs the exporter ended spans go to. Without one, spans
+// are still created and propagated but not exported.
+func SetExporter(e Exporter) {
+	exporterMu.Lock()
+	defer exporterMu.Unlock()
+	exporter = e
+}
+
+func currentExporter() Exporter {
+	exporterMu.RLock()
+	defer exporterMu.RUnlock()
+	return exporter
+}
+
+// Shutdown flushes and removes the installed exporter
+func Shutdown(ctx context.Context) error {
+	exporterMu.Lock()
+	e := exporter
+	exporter = nil
+	exporterMu.Unlock()
+	if e == nil {
+		return nil
+	}
+	return e.Shutdown(ctx)
+}
+
+// Setup installs the exporter named by kind: "stdout", "otlp" (OTLP/HTTP
+// JSON to endpoint, e.g. http://localhost:4318) or "none"
+func Setup(service, kind, endpoint string) error {
+	switch kind {
+	case "", "none":
+		SetExporter(nil)
+	case "stdout":
+		SetExporter(NewStdoutExporter(os.Stdout, service))
+	case "otlp":
+		SetExporter(NewOTLPExporter(endpoint, service))
+	default:
+		return fmt.Errorf("unknown trace exporter %q", kind)
+	}
+	return nil
+}
+
+// StdoutExporter writes one JSON object per span, for local debugging
+type StdoutExporter struct {
+	service string
+	mu      sync.Mutex
+	enc     *json.Encoder
+}
+
+func NewStdoutExporter(w io.Writer, service string) *StdoutExporter {
+	return &StdoutExporter{service: service, enc: json.NewEncoder(w)}
+}
+
+type stdoutSpan struct {
+	Service    string         `json:"service"`
+	Name       string         `json:"name"`
+	Kind       string         `json:"kind"`
+	TraceID    string         `json:"trace_id"`
+	SpanID     string         `json:"span_id"`
+	ParentID   string         `json:"parent_id,omitempty"`
+	Start      time.Time      `json:"start"`
+	DurationMS float64        `json:"duration_ms"`
+	Error      string         `json:"error,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+func (e *StdoutExporter) Export(span *Span) {
+	out := stdoutSpan{
+		Service:    e.service,
+		Name:       span.Name,
+		Kind:       span.Kind.String(),
+		TraceID:    span.Context.TraceID.String(),
+		SpanID:     span.Context.SpanID.String(),
+		Start:      span.Start,
+		DurationMS: float64(span.EndTime.Sub(span.Start).Microseconds()) / 1000,
+	}
+	if span.Parent.IsValid() {
+		out.ParentID = span.Parent.String()
+	}
+	if span.Status == StatusError {
+		out.Error = span.StatusMessage
+	}
+	if len(span.Attributes) > 0 {
+		out.Attributes = make(map[string]any, len(span.Attributes))
+		for _, a := range span.Attributes {
+			out.Attributes[a.Key] = a.Value
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enc.Encode(out)
+}
+
+func (e *StdoutExporter) Shutdown(context.Context) error { return nil }
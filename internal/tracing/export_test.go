@@ -0,0 +1,362 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector is an OTLP/HTTP endpoint recording the requests it receives
+type collector struct {
+	mu       sync.Mutex
+	requests []map[string]any
+	paths    []string
+	types    []string
+	status   int
+	block    chan struct{} // when set, requests wait for it to be closed
+}
+
+func newCollector(t *testing.T) (*collector, *httptest.Server) {
+	c := &collector{status: http.StatusOK}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.block != nil {
+			<-c.block
+		}
+		var req map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("collector: %v", err)
+		}
+		c.mu.Lock()
+		c.requests = append(c.requests, req)
+		c.paths = append(c.paths, r.URL.Path)
+		c.types = append(c.types, r.Header.Get("Content-Type"))
+		status := c.status
+		c.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return c, srv
+}
+
+// spans returns the spans of every request, in order
+func (c *collector) spans() []map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var spans []map[string]any
+	for _, req := range c.requests {
+		for _, rs := range req["resourceSpans"].([]any) {
+			for _, ss := range rs.(map[string]any)["scopeSpans"].([]any) {
+				for _, s := range ss.(map[string]any)["spans"].([]any) {
+					spans = append(spans, s.(map[string]any))
+				}
+			}
+		}
+	}
+	return spans
+}
+
+// batchSizes returns the number of spans in each request
+func (c *collector) batchSizes() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var sizes []int
+	for _, req := range c.requests {
+		rs := req["resourceSpans"].([]any)[0].(map[string]any)
+		sizes = append(sizes, len(rs["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)))
+	}
+	return sizes
+}
+
+func testSpans() []*Span {
+	start := time.Unix(1_700_000_000, 123_456_789)
+	traceID := TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+	root := &Span{
+		Name:    "peril_topic publish",
+		Kind:    KindProducer,
+		Context: SpanContext{TraceID: traceID, SpanID: SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}, Flags: FlagSampled, TraceState: "congo=t61rcWkgMzE"},
+		Start:   start,
+		EndTime: start.Add(1500 * time.Microsecond),
+		Attributes: []Attribute{
+			String("messaging.system", "rabbitmq"),
+			Int("messaging.batch.message_count", 3),
+			Bool("messaging.rabbitmq.mandatory", true),
+			{"messaging.message.body.size", int64(1 << 40)},
+			{"order.total", 249.99},
+			{"order.region", struct{ Code string }{"eu"}},
+		},
+		Status: StatusOK,
+	}
+	child := &Span{
+		Name:          "orders_queue process",
+		Kind:          KindConsumer,
+		Context:       SpanContext{TraceID: traceID, SpanID: SpanID{0xb7, 0xad, 0x6b, 0x71, 0x69, 0x20, 0x33, 0x31}, Flags: FlagSampled},
+		Parent:        root.Context.SpanID,
+		Start:         start.Add(2 * time.Millisecond),
+		EndTime:       start.Add(5 * time.Millisecond),
+		Status:        StatusError,
+		StatusMessage: "payment declined",
+	}
+	return []*Span{root, child}
+}
+
+func TestOTLPExporterPayload(t *testing.T) {
+	c, srv := newCollector(t)
+	e := NewOTLPExporter(srv.URL+"/", "order-producer")
+	for _, s := range testSpans() {
+		e.Export(s)
+	}
+	if err := e.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	if len(c.requests) != 1 {
+		t.Fatalf("collector got %d requests, want 1", len(c.requests))
+	}
+	if c.paths[0] != "/v1/traces" || c.types[0] != "application/json" {
+		t.Errorf("posted %s to %s, want application/json to /v1/traces", c.types[0], c.paths[0])
+	}
+	rs := c.requests[0]["resourceSpans"].([]any)[0].(map[string]any)
+	wantResource := map[string]any{"attributes": []any{
+		map[string]any{"key": "service.name", "value": map[string]any{"stringValue": "order-producer"}},
+	}}
+	if !reflect.DeepEqual(rs["resource"], wantResource) {
+		t.Errorf("resource = %v, want %v", rs["resource"], wantResource)
+	}
+
+	want := []map[string]any{
+		{
+			"traceId":           "4bf92f3577b34da6a3ce929d0e0e4736",
+			"spanId":            "00f067aa0ba902b7",
+			"traceState":        "congo=t61rcWkgMzE",
+			"name":              "peril_topic publish",
+			"kind":              4.0, // SPAN_KIND_PRODUCER
+			"startTimeUnixNano": "1700000000123456789",
+			"endTimeUnixNano":   "1700000000124956789",
+			"attributes": []any{
+				map[string]any{"key": "messaging.system", "value": map[string]any{"stringValue": "rabbitmq"}},
+				map[string]any{"key": "messaging.batch.message_count", "value": map[string]any{"intValue": "3"}},
+				map[string]any{"key": "messaging.rabbitmq.mandatory", "value": map[string]any{"boolValue": true}},
+				map[string]any{"key": "messaging.message.body.size", "value": map[string]any{"intValue": "1099511627776"}},
+				map[string]any{"key": "order.total", "value": map[string]any{"doubleValue": 249.99}},
+				map[string]any{"key": "order.region", "value": map[string]any{"stringValue": "{eu}"}},
+			},
+			"status": map[string]any{"code": 1.0}, // STATUS_CODE_OK
+		},
+		{
+			"traceId":           "4bf92f3577b34da6a3ce929d0e0e4736",
+			"spanId":            "b7ad6b7169203331",
+			"parentSpanId":      "00f067aa0ba902b7",
+			"name":              "orders_queue process",
+			"kind":              5.0, // SPAN_KIND_CONSUMER
+			"startTimeUnixNano": "1700000000125456789",
+			"endTimeUnixNano":   "1700000000128456789",
+			"status":            map[string]any{"code": 2.0, "message": "payment declined"}, // STATUS_CODE_ERROR
+		},
+	}
+	got := c.spans()
+	if len(got) != len(want) {
+		t.Fatalf("exported %d spans, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			g, _ := json.MarshalIndent(got[i], "", "  ")
+			w, _ := json.MarshalIndent(want[i], "", "  ")
+			t.Errorf("span %d =\n%s\nwant\n%s", i, g, w)
+		}
+	}
+}
+
+func TestOTLPExporterBatches(t *testing.T) {
+	c, srv := newCollector(t)
+	e := NewOTLPExporter(srv.URL, "order-producer")
+	e.BatchSize = 2
+	e.FlushInterval = time.Hour
+	for i := 0; i < 5; i++ {
+		e.Export(testSpans()[0])
+	}
+	// full batches go out on their own, the rest on Shutdown
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if got := c.batchSizes(); !reflect.DeepEqual(got, []int{2, 2, 1}) {
+		t.Errorf("batch sizes = %v, want [2 2 1]", got)
+	}
+}
+
+func TestOTLPExporterFlushInterval(t *testing.T) {
+	c, srv := newCollector(t)
+	e := NewOTLPExporter(srv.URL, "order-producer")
+	e.FlushInterval = 10 * time.Millisecond
+	defer e.Shutdown(context.Background())
+	e.Export(testSpans()[0])
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(c.spans()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("span was not sent after the flush interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestOTLPExporterShutdown(t *testing.T) {
+	t.Run("flush and shutdown after shutdown", func(t *testing.T) {
+		c, srv := newCollector(t)
+		e := NewOTLPExporter(srv.URL, "order-producer")
+		e.Export(testSpans()[0])
+		if err := e.Shutdown(context.Background()); err != nil {
+			t.Fatalf("Shutdown: %v", err)
+		}
+		if err := e.Shutdown(context.Background()); err != nil {
+			t.Errorf("second Shutdown: %v", err)
+		}
+		if err := e.Flush(context.Background()); err != nil {
+			t.Errorf("Flush after Shutdown: %v", err)
+		}
+		if n := len(c.spans()); n != 1 {
+			t.Errorf("exported %d spans, want 1", n)
+		}
+	})
+
+	t.Run("gives up with the context", func(t *testing.T) {
+		c, srv := newCollector(t)
+		c.block = make(chan struct{})
+		defer close(c.block)
+		e := NewOTLPExporter(srv.URL, "order-producer")
+		e.Export(testSpans()[0])
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := e.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Shutdown = %v, want deadline exceeded", err)
+		}
+		if err := e.Flush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Flush = %v, want deadline exceeded", err)
+		}
+	})
+
+	t.Run("collector errors drop the batch", func(t *testing.T) {
+		c, srv := newCollector(t)
+		c.status = http.StatusServiceUnavailable
+		e := NewOTLPExporter(srv.URL, "order-producer")
+		e.Export(testSpans()[0])
+		if err := e.Shutdown(context.Background()); err != nil {
+			t.Fatalf("Shutdown: %v", err)
+		}
+		if n := len(c.requests); n != 1 {
+			t.Errorf("collector got %d requests, want 1 with no retry", n)
+		}
+	})
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewStdoutExporter(&buf, "order-consumer")
+	for _, s := range testSpans() {
+		e.Export(s)
+	}
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	want := []string{
+		`{"service":"order-consumer","name":"peril_topic publish","kind":"producer",` +
+			`"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736","span_id":"00f067aa0ba902b7",` +
+			`"start":"` + testSpans()[0].Start.Format(time.RFC3339Nano) + `","duration_ms":1.5,` +
+			`"attributes":{"messaging.batch.message_count":3,"messaging.message.body.size":1099511627776,` +
+			`"messaging.rabbitmq.mandatory":true,"messaging.system":"rabbitmq","order.region":{"Code":"eu"},"order.total":249.99}}`,
+		`{"service":"order-consumer","name":"orders_queue process","kind":"consumer",` +
+			`"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736","span_id":"b7ad6b7169203331","parent_id":"00f067aa0ba902b7",` +
+			`"start":"` + testSpans()[1].Start.Format(time.RFC3339Nano) + `","duration_ms":3,"error":"payment declined"}`,
+	}
+	got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrote\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSetup(t *testing.T) {
+	defer SetExporter(nil)
+	tests := []struct {
+		kind    string
+		want    Exporter
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"none", nil, false},
+		{"stdout", &StdoutExporter{}, false},
+		{"otlp", &OTLPExporter{}, false},
+		{"zipkin", &OTLPExporter{}, true}, // keeps the installed one
+	}
+	for _, tt := range tests {
+		err := Setup("order-producer", tt.kind, "http://localhost:4318")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Setup(%q) error = %v, want error %v", tt.kind, err, tt.wantErr)
+		}
+		if got := currentExporter(); reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+			t.Errorf("Setup(%q) installed %T, want %T", tt.kind, got, tt.want)
+		}
+	}
+}
+
+func TestOTLPExporterLogs(t *testing.T) {
+	type record struct {
+		Level   string `json:"level"`
+		Msg     string `json:"msg"`
+		Span    string `json:"span"`
+		Spans   int    `json:"spans"`
+		TraceID string `json:"trace_id"`
+		Error   string `json:"error"`
+	}
+	c, srv := newCollector(t)
+	c.status = http.StatusBadRequest
+	c.block = make(chan struct{})
+	var buf bytes.Buffer
+	e := NewOTLPExporter(srv.URL, "order-producer")
+	e.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	e.BatchSize = 1
+	e.spans = make(chan *Span, 1)
+
+	// the first span is being sent, the second waits in the buffer and the
+	// third is dropped
+	span := testSpans()[0]
+	e.Export(span)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(e.spans) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the exporter did not pick up the span")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	e.Export(span)
+	e.Export(span)
+	close(c.block)
+	e.Shutdown(context.Background())
+
+	var got []record
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r record
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("log %q: %v", buf.String(), err)
+		}
+		got = append(got, r)
+	}
+	want := []record{
+		{Level: "WARN", Msg: "trace buffer full, dropping span", Span: span.Name, TraceID: span.Context.TraceID.String()},
+		{Level: "ERROR", Msg: "failed to export spans", Spans: 1, Error: "collector returned 400 Bad Request: "},
+		{Level: "ERROR", Msg: "failed to export spans", Spans: 1, Error: "collector returned 400 Bad Request: "},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("logged %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+// internal/tracing/propagation.go
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// W3C Trace Context header names
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// Carrier reads and writes propagation fields, e.g. message or HTTP headers
+type Carrier interface {
+	Get(key string) string
+	Set(key, value string)
+}
+
+// TableCarrier adapts AMQP headers; convert with TableCarrier(msg.Headers)
+// after making sure the table is not nil
+type TableCarrier map[string]any
+
+func (c TableCarrier) Get(key string) string {
+	switch v := c[key].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+func (c TableCarrier) Set(key, value string) { c[key] = value }
+
+// HeaderCarrier adapts HTTP headers
+type HeaderCarrier http.Header
+
+func (c HeaderCarrier) Get(key string) string { return http.Header(c).Get(key) }
+func (c HeaderCarrier) Set(key, value string) { http.Header(c).Set(key, value) }
+
+// Inject writes the span context of ctx into carrier
+func Inject(ctx context.Context, carrier Carrier) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	carrier.Set(TraceparentHeader, FormatTraceparent(sc))
+	if sc.TraceState != "" {
+		carrier.Set(TracestateHeader, sc.TraceState)
+	}
+}
+
+// Extract returns ctx continuing the trace found in carrier, or ctx
+// unchanged when carrier has no valid traceparent
+func Extract(ctx context.Context, carrier Carrier) context.Context {
+	sc, err := ParseTraceparent(carrier.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	sc.TraceState = carrier.Get(TracestateHeader)
+	sc.Remote = true
+	return ContextWithRemote(ctx, sc)
+}
+
+// FormatTraceparent renders sc as a version 00 traceparent
+func FormatTraceparent(sc SpanContext) string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent parses a traceparent header. Future versions are
+// accepted as long as they start with the version 00 fields.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || version == "ff" || (version == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("unsupported traceparent version in %q", s)
+	}
+	if err := decodeHex(sc.TraceID[:], traceID); err != nil {
+		return sc, fmt.Errorf("invalid trace id in %q", s)
+	}
+	if err := decodeHex(sc.SpanID[:], spanID); err != nil {
+		return sc, fmt.Errorf("invalid span id in %q", s)
+	}
+	var f [1]byte
+	if err := decodeHex(f[:], flags); err != nil {
+		return sc, fmt.Errorf("invalid trace flags in %q", s)
+	}
+	sc.Flags = f[0]
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("all-zero ids in traceparent %q", s)
+	}
+	return sc, nil
+}
+
+// decodeHex fills dst from lowercase hex of exactly the right length
+func decodeHex(dst []byte, s string) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return fmt.Errorf("bad length or case")
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
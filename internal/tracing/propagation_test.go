@@ -0,0 +1,118 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		name    string
+		in      string
+		wantErr bool
+		flags   byte
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", false, 0x01},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", false, 0x00},
+		{"surrounding whitespace", "  00-" + traceID + "-" + spanID + "-01\n", false, 0x01},
+		{"future version with more fields", "cc-" + traceID + "-" + spanID + "-01-what-the-future-holds", false, 0x01},
+		{"empty", "", true, 0},
+		{"too few fields", "00-" + traceID + "-" + spanID, true, 0},
+		{"version 00 with extra fields", "00-" + traceID + "-" + spanID + "-01-extra", true, 0},
+		{"forbidden version ff", "ff-" + traceID + "-" + spanID + "-01", true, 0},
+		{"three digit version", "000-" + traceID + "-" + spanID + "-01", true, 0},
+		{"uppercase trace id", "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01", true, 0},
+		{"short trace id", "00-4bf92f3577b34da6a3ce929d0e0e473-" + spanID + "-01", true, 0},
+		{"short span id", "00-" + traceID + "-00f067aa0ba902b-01", true, 0},
+		{"non-hex span id", "00-" + traceID + "-00f067aa0ba902bz-01", true, 0},
+		{"bad flags", "00-" + traceID + "-" + spanID + "-1", true, 0},
+		{"zero trace id", "00-00000000000000000000000000000000-" + spanID + "-01", true, 0},
+		{"zero span id", "00-" + traceID + "-0000000000000000-01", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseTraceparent(%q) = %+v, want an error", tt.in, sc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTraceparent(%q): %v", tt.in, err)
+			}
+			if sc.TraceID.String() != traceID || sc.SpanID.String() != spanID || sc.Flags != tt.flags {
+				t.Errorf("ParseTraceparent(%q) = %s %s %02x", tt.in, sc.TraceID, sc.SpanID, sc.Flags)
+			}
+			// formatting gives back the version 00 form
+			want := fmt.Sprintf("00-%s-%s-%02x", traceID, spanID, tt.flags)
+			if got := FormatTraceparent(sc); got != want {
+				t.Errorf("FormatTraceparent = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	ctx, span := Start(context.Background(), "publish", KindProducer)
+	span.Context.TraceState = "vendor=value"
+	ctx = ContextWithSpan(ctx, span)
+
+	tests := []struct {
+		name    string
+		carrier Carrier
+		// raw rewrites what Inject wrote, as a broker or proxy might
+		raw func(c Carrier)
+	}{
+		{"amqp headers", TableCarrier{}, nil},
+		{"amqp headers as bytes", TableCarrier{}, func(c Carrier) {
+			tc := c.(TableCarrier)
+			tc[TraceparentHeader] = []byte(tc.Get(TraceparentHeader))
+		}},
+		{"http headers", HeaderCarrier(http.Header{}), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Inject(ctx, tt.carrier)
+			if tt.raw != nil {
+				tt.raw(tt.carrier)
+			}
+			_, child := Start(Extract(context.Background(), tt.carrier), "process", KindConsumer)
+			if child.Context.TraceID != span.Context.TraceID || child.Parent != span.Context.SpanID {
+				t.Errorf("child %s/%s of %s, want trace %s parent %s",
+					child.Context.TraceID, child.Context.SpanID, child.Parent, span.Context.TraceID, span.Context.SpanID)
+			}
+			if child.Context.TraceState != "vendor=value" {
+				t.Errorf("tracestate = %q", child.Context.TraceState)
+			}
+		})
+	}
+}
+
+func TestExtractWithoutTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		carrier Carrier
+	}{
+		{"no header", TableCarrier{}},
+		{"garbage", TableCarrier{TraceparentHeader: "not-a-traceparent"}},
+		{"wrong type", TableCarrier{TraceparentHeader: 42}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := Extract(context.Background(), tt.carrier)
+			if sc := SpanContextFromContext(ctx); sc.IsValid() {
+				t.Errorf("Extract found %+v", sc)
+			}
+			if _, span := Start(ctx, "root", KindConsumer); span.Parent.IsValid() || !span.Context.Sampled() {
+				t.Errorf("span without traceparent is not a sampled root: %+v", span.Context)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+// internal/tracing/span.go
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TraceID and SpanID identify traces and spans as in W3C Trace Context
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+func (s SpanID) IsValid() bool  { return s != SpanID{} }
+
+// FlagSampled marks a trace whose spans are exported
+const FlagSampled byte = 0x01
+
+// SpanContext is the part of a span that crosses process boundaries
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string // vendor data, passed through untouched
+	Remote     bool   // extracted from a message or request
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// SpanKind follows the OpenTelemetry span kinds
+type SpanKind int
+
+const (
+	KindInternal SpanKind = iota + 1
+	KindServer
+	KindClient
+	KindProducer
+	KindConsumer
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	case KindProducer:
+		return "producer"
+	case KindConsumer:
+		return "consumer"
+	}
+	return "internal"
+}
+
+// StatusCode follows the OpenTelemetry span status codes
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = iota
+	StatusOK
+	StatusError
+)
+
+// Attribute is a key/value pair on a span. Values are strings, bools,
+// ints, int64s or float64s.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// String, Int and Bool build attributes
+func String(key, value string) Attribute    { return Attribute{key, value} }
+func Int(key string, value int) Attribute   { return Attribute{key, value} }
+func Bool(key string, value bool) Attribute { return Attribute{key, value} }
+
+// Span is one timed operation. It must not be modified after End.
+type Span struct {
+	Name          string
+	Kind          SpanKind
+	Context       SpanContext
+	Parent        SpanID
+	Start         time.Time
+	EndTime       time.Time
+	Attributes    []Attribute
+	Status        StatusCode
+	StatusMessage string
+
+	mu    sync.Mutex
+	ended bool
+}
+
+// SetAttributes adds attributes to the span
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.Attributes = append(s.Attributes, attrs...)
+	}
+}
+
+// SetError marks the span as failed; a nil err does nothing
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.Status = StatusError
+		s.StatusMessage = err.Error()
+	}
+}
+
+// End finishes the span and hands it to the exporter if it is sampled
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+
+	if s.Context.Sampled() {
+		if e := currentExporter(); e != nil {
+			e.Export(s)
+		}
+	}
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// ContextWithSpan returns a context carrying span as the current span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemote returns a context whose next span continues the remote
+// trace sc, e.g. one extracted from an incoming HTTP request
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context new spans in ctx would
+// descend from: the current span's, else a remote one
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.Context
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+// Start begins a span that is a child of the span in ctx, or the root of
+// a new trace, and returns a context carrying it. Children share the
+// sampling decision of their parent; new traces are sampled at the
+// ratio set by SetSampleRatio.
+func Start(ctx context.Context, name string, kind SpanKind, attrs ...Attribute) (context.Context, *Span) {
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: attrs,
+	}
+	parent := SpanContextFromContext(ctx)
+	if parent.IsValid() {
+		span.Context = SpanContext{TraceID: parent.TraceID, Flags: parent.Flags, TraceState: parent.TraceState}
+		span.Parent = parent.SpanID
+	} else {
+		span.Context = SpanContext{TraceID: newTraceID()}
+		if sampled(span.Context.TraceID, currentSampleRatio()) {
+			span.Context.Flags = FlagSampled
+		}
+	}
+	span.Context.SpanID = newSpanID()
+	return ContextWithSpan(ctx, span), span
+}
+
+var (
+	samplerMu   sync.RWMutex
+	sampleRatio = 1.0
+)
+
+// SetSampleRatio sets the fraction of new traces that are sampled, from 0
+// (none) to 1 (all, the default). Traces continued from a message or
+// request keep the decision of their caller.
+func SetSampleRatio(ratio float64) {
+	samplerMu.Lock()
+	defer samplerMu.Unlock()
+	sampleRatio = ratio
+}
+
+func currentSampleRatio() float64 {
+	samplerMu.RLock()
+	defer samplerMu.RUnlock()
+	return sampleRatio
+}
+
+// sampled decides from the random low half of the trace id, like the
+// OpenTelemetry TraceIDRatioBased sampler, so every service that sees
+// the id alone comes to the same decision
+func sampled(id TraceID, ratio float64) bool {
+	switch {
+	case ratio >= 1:
+		return true
+	case ratio <= 0:
+		return false
+	}
+	return binary.BigEndian.Uint64(id[8:])>>1 < uint64(ratio*(1<<63))
+}
+
+func newTraceID() (id TraceID) {
+	randomID(id[:])
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	randomID(id[:])
+	return id
+}
+
+func randomID(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("tracing: failed to generate id: %v", err))
+	}
+}
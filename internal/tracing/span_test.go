@@ -0,0 +1,85 @@
+package tracing
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+// recordingExporter keeps the spans it is given
+type recordingExporter struct{ spans []*Span }
+
+func (e *recordingExporter) Export(span *Span)              { e.spans = append(e.spans, span) }
+func (e *recordingExporter) Shutdown(context.Context) error { return nil }
+
+func TestSampled(t *testing.T) {
+	const traces = 20_000
+	for _, ratio := range []float64{0, 0.01, 0.25, 0.5, 1} {
+		n := 0
+		for i := 0; i < traces; i++ {
+			if sampled(newTraceID(), ratio) {
+				n++
+			}
+		}
+		if got := float64(n) / traces; math.Abs(got-ratio) > 0.02 {
+			t.Errorf("ratio %v sampled %v of the traces", ratio, got)
+		}
+	}
+
+	// the decision only depends on the trace id
+	id := newTraceID()
+	for i := 0; i < 10; i++ {
+		if sampled(id, 0.5) != sampled(id, 0.5) {
+			t.Fatal("sampled changed its mind about one trace id")
+		}
+	}
+	low, high := TraceID{}, TraceID{}
+	for i := range high {
+		high[i] = 0xff
+	}
+	if !sampled(low, 0.001) || sampled(high, 0.999) {
+		t.Error("the lowest id must be sampled first and the highest last")
+	}
+}
+
+func TestStartSampling(t *testing.T) {
+	e := &recordingExporter{}
+	SetExporter(e)
+	defer SetExporter(nil)
+	defer SetSampleRatio(1)
+
+	tests := []struct {
+		name        string
+		ratio       float64
+		parent      SpanContext
+		wantSampled bool
+	}{
+		{"root sampled by default", 1, SpanContext{}, true},
+		{"root not sampled", 0, SpanContext{}, false},
+		{"sampled parent is followed", 0, SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Flags: FlagSampled, Remote: true}, true},
+		{"unsampled parent is followed", 1, SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Remote: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetSampleRatio(tt.ratio)
+			e.spans = nil
+			ctx := context.Background()
+			if tt.parent.IsValid() {
+				ctx = ContextWithRemote(ctx, tt.parent)
+			}
+			ctx, root := Start(ctx, "publish", KindProducer)
+			_, child := Start(ctx, "encode", KindInternal)
+			child.End()
+			root.End()
+
+			for _, s := range []*Span{root, child} {
+				if s.Context.Sampled() != tt.wantSampled {
+					t.Errorf("%s sampled = %v, want %v", s.Name, s.Context.Sampled(), tt.wantSampled)
+				}
+			}
+			if want := map[bool]int{true: 2, false: 0}[tt.wantSampled]; len(e.spans) != want {
+				t.Errorf("exported %d spans, want %d", len(e.spans), want)
+			}
+		})
+	}
+}